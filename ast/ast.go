@@ -4,6 +4,10 @@ import "gmachine/token"
 
 type Node interface {
 	TokenLiteral() string
+	// Pos returns the position of the node's first character.
+	Pos() token.Position
+	// End returns the position just after the node's last character.
+	End() token.Position
 }
 
 type Statement interface {
@@ -27,6 +31,32 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{Line: 1, Column: 1}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{Line: 1, Column: 1}
+}
+
+// end returns the end of the last of nodes that is present, or else the end
+// of tok, for statements whose trailing parts may be missing after a syntax
+// error.
+func end(tok token.Token, nodes ...Node) token.Position {
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if nodes[i] != nil && nodes[i].End().Line != 0 {
+			return nodes[i].End()
+		}
+	}
+	return tok.End
+}
+
 type ConstantDefinitionStatement struct {
 	Token token.Token // the token.CONSTANT_DEFINITION token
 	Name  Identifier
@@ -35,6 +65,10 @@ type ConstantDefinitionStatement struct {
 
 func (cds ConstantDefinitionStatement) statementNode()       {}
 func (cds ConstantDefinitionStatement) TokenLiteral() string { return cds.Token.Literal }
+func (cds ConstantDefinitionStatement) Pos() token.Position  { return cds.Token.Pos() }
+func (cds ConstantDefinitionStatement) End() token.Position {
+	return end(cds.Token, cds.Name, cds.Value)
+}
 
 type VariableDefinitionStatement struct {
 	Token token.Token // the token.VARIABLE_DEFINITION token
@@ -44,6 +78,10 @@ type VariableDefinitionStatement struct {
 
 func (vds VariableDefinitionStatement) statementNode()       {}
 func (vds VariableDefinitionStatement) TokenLiteral() string { return vds.Token.Literal }
+func (vds VariableDefinitionStatement) Pos() token.Position  { return vds.Token.Pos() }
+func (vds VariableDefinitionStatement) End() token.Position {
+	return end(vds.Token, vds.Name, vds.Value)
+}
 
 type LabelDefinitionStatement struct {
 	Token token.Token // the token.LABEL_DEFINITION token
@@ -51,6 +89,59 @@ type LabelDefinitionStatement struct {
 
 func (lds LabelDefinitionStatement) statementNode()       {}
 func (lds LabelDefinitionStatement) TokenLiteral() string { return lds.Token.Literal }
+func (lds LabelDefinitionStatement) Pos() token.Position  { return lds.Token.Pos() }
+func (lds LabelDefinitionStatement) End() token.Position  { return lds.Token.End }
+
+// IncludeStatement includes the statements of another source file, as if
+// they appeared in its place.
+type IncludeStatement struct {
+	Token token.Token // the token.INCLUDE token
+	Path  StringLiteral
+}
+
+func (is IncludeStatement) statementNode()       {}
+func (is IncludeStatement) TokenLiteral() string { return is.Token.Literal }
+func (is IncludeStatement) Pos() token.Position  { return is.Token.Pos() }
+func (is IncludeStatement) End() token.Position  { return end(is.Token, is.Path) }
+
+// MacroDefinitionStatement defines a macro, from its MACRO line to its
+// ENDM line. Its parameters stand for the arguments it's called with.
+type MacroDefinitionStatement struct {
+	Token  token.Token // the token.MACRO_DEFINITION token
+	Name   Identifier
+	Params []Identifier
+	Body   []Statement
+	Endm   token.Token // the token.MACRO_END token, if there is one
+}
+
+func (mds MacroDefinitionStatement) statementNode()       {}
+func (mds MacroDefinitionStatement) TokenLiteral() string { return mds.Token.Literal }
+func (mds MacroDefinitionStatement) Pos() token.Position  { return mds.Token.Pos() }
+func (mds MacroDefinitionStatement) End() token.Position {
+	if mds.Endm.Type == token.MACRO_END {
+		return mds.Endm.End
+	}
+	if len(mds.Body) > 0 {
+		return mds.Body[len(mds.Body)-1].End()
+	}
+	return end(mds.Token, mds.Name)
+}
+
+type MacroCallStatement struct {
+	Token     token.Token // the token.IDENT token naming the macro
+	Arguments []Expression
+}
+
+func (mcs MacroCallStatement) statementNode()       {}
+func (mcs MacroCallStatement) TokenLiteral() string { return mcs.Token.Literal }
+func (mcs MacroCallStatement) Pos() token.Position  { return mcs.Token.Pos() }
+func (mcs MacroCallStatement) End() token.Position {
+	args := make([]Node, len(mcs.Arguments))
+	for i, arg := range mcs.Arguments {
+		args[i] = arg
+	}
+	return end(mcs.Token, args...)
+}
 
 type InstructionStatement struct {
 	Token    token.Token // the token.OPCODE token
@@ -60,6 +151,10 @@ type InstructionStatement struct {
 
 func (os InstructionStatement) statementNode()       {}
 func (os InstructionStatement) TokenLiteral() string { return os.Token.Literal }
+func (os InstructionStatement) Pos() token.Position  { return os.Token.Pos() }
+func (os InstructionStatement) End() token.Position {
+	return end(os.Token, os.Operand1, os.Operand2)
+}
 
 type RegisterLiteral struct {
 	Token        token.Token // the token.REGISTER token
@@ -68,6 +163,8 @@ type RegisterLiteral struct {
 
 func (rl RegisterLiteral) expressionNode()      {}
 func (rl RegisterLiteral) TokenLiteral() string { return rl.Token.Literal }
+func (rl RegisterLiteral) Pos() token.Position  { return rl.Token.Pos() }
+func (rl RegisterLiteral) End() token.Position  { return rl.Token.End }
 
 type Identifier struct {
 	Token token.Token // the token.IDENT token
@@ -76,6 +173,8 @@ type Identifier struct {
 
 func (i Identifier) expressionNode()      {}
 func (i Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i Identifier) Pos() token.Position  { return i.Token.Pos() }
+func (i Identifier) End() token.Position  { return i.Token.End }
 
 type IntegerLiteral struct {
 	Token token.Token // the token.INT token
@@ -84,6 +183,8 @@ type IntegerLiteral struct {
 
 func (il IntegerLiteral) expressionNode()      {}
 func (il IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il IntegerLiteral) Pos() token.Position  { return il.Token.Pos() }
+func (il IntegerLiteral) End() token.Position  { return il.Token.End }
 
 type CharacterLiteral struct {
 	Token token.Token // the token.CHAR token
@@ -92,6 +193,8 @@ type CharacterLiteral struct {
 
 func (cl CharacterLiteral) expressionNode()      {}
 func (cl CharacterLiteral) TokenLiteral() string { return cl.Token.Literal }
+func (cl CharacterLiteral) Pos() token.Position  { return cl.Token.Pos() }
+func (cl CharacterLiteral) End() token.Position  { return cl.Token.End }
 
 type StringLiteral struct {
 	Token token.Token // the token.STRING token
@@ -100,3 +203,52 @@ type StringLiteral struct {
 
 func (sl StringLiteral) expressionNode()      {}
 func (sl StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl StringLiteral) Pos() token.Position  { return sl.Token.Pos() }
+func (sl StringLiteral) End() token.Position  { return sl.Token.End }
+
+// CurrentAddress is the $ symbol, which stands for the address of the
+// statement it appears in.
+type CurrentAddress struct {
+	Token token.Token // the token.DOLLAR token
+}
+
+func (ca CurrentAddress) expressionNode()      {}
+func (ca CurrentAddress) TokenLiteral() string { return ca.Token.Literal }
+func (ca CurrentAddress) Pos() token.Position  { return ca.Token.Pos() }
+func (ca CurrentAddress) End() token.Position  { return ca.Token.End }
+
+type PrefixExpression struct {
+	Token    token.Token // the operator token, such as token.MINUS
+	Operator string
+	Right    Expression
+}
+
+func (pe PrefixExpression) expressionNode()      {}
+func (pe PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe PrefixExpression) Pos() token.Position  { return pe.Token.Pos() }
+func (pe PrefixExpression) End() token.Position  { return end(pe.Token, pe.Right) }
+
+type InfixExpression struct {
+	Token    token.Token // the operator token, such as token.PLUS
+	Left     Expression
+	Operator string
+	Right    Expression
+}
+
+func (ie InfixExpression) expressionNode()      {}
+func (ie InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie InfixExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie InfixExpression) End() token.Position  { return end(ie.Token, ie.Right) }
+
+// CallExpression is a call to a built-in function, such as len(msg).
+type CallExpression struct {
+	Token    token.Token // the token.LPAREN token
+	Function Identifier
+	Argument Expression
+	Rparen   token.Token // the token.RPAREN token
+}
+
+func (ce CallExpression) expressionNode()      {}
+func (ce CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce CallExpression) Pos() token.Position  { return ce.Function.Pos() }
+func (ce CallExpression) End() token.Position  { return ce.Rparen.End }
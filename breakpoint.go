@@ -0,0 +1,136 @@
+package gmachine
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrBreakpoint error = errors.New("breakpoint")
+var ErrWatchpoint error = errors.New("watchpoint")
+
+// Stop is returned by Run when execution reaches a breakpoint, or an
+// instruction changes a watched register or memory word. Running the machine
+// again resumes execution where it stopped.
+type Stop struct {
+	// Reason is either ErrBreakpoint or ErrWatchpoint.
+	Reason error
+	// P is the address of the next instruction to execute.
+	P Word
+
+	// For watchpoints, Register reports whether Location identifies a
+	// register or a memory address, and Old and New are the watched values
+	// before and after the instruction at At executed.
+	Register bool
+	Location Word
+	Old      Word
+	New      Word
+	At       Word
+}
+
+func (s *Stop) Error() string {
+	if s.Reason == ErrWatchpoint {
+		return fmt.Sprintf("%s %s changed from %d to %d at P=%d", s.Reason, s.location(), s.Old, s.New, s.At)
+	}
+	return fmt.Sprintf("%s at P=%d", s.Reason, s.P)
+}
+
+func (s *Stop) Unwrap() error {
+	return s.Reason
+}
+
+func (s *Stop) location() string {
+	if s.Register {
+		return registerName(s.Location)
+	}
+	return fmt.Sprintf("[%d]", s.Location)
+}
+
+type watchpoint struct {
+	register bool
+	location Word
+	value    Word
+}
+
+// SetBreakpoint stops Run before it executes the instruction at addr, which
+// is relative to MemOffset.
+func (g *Machine) SetBreakpoint(addr Word) {
+	if g.breakpoints == nil {
+		g.breakpoints = make(map[Word]bool)
+	}
+	g.breakpoints[addr] = true
+}
+
+// ClearBreakpoint removes the breakpoint at addr, if any.
+func (g *Machine) ClearBreakpoint(addr Word) {
+	delete(g.breakpoints, addr)
+}
+
+// Breakpoint reports whether there is a breakpoint at addr.
+func (g *Machine) Breakpoint(addr Word) bool {
+	return g.breakpoints[addr]
+}
+
+// WatchMemory stops Run after any instruction that changes the word at addr,
+// which is relative to MemOffset.
+func (g *Machine) WatchMemory(addr Word) {
+	g.watchpoints = append(g.watchpoints, watchpoint{
+		location: addr,
+		value:    g.watchedValue(false, addr),
+	})
+}
+
+// WatchRegister stops Run after any instruction that changes the register
+// identified by reg, which must be one of RegA, RegX or RegY.
+func (g *Machine) WatchRegister(reg Word) error {
+	switch reg {
+	case RegA, RegX, RegY:
+	default:
+		return fmt.Errorf("%w: %d", ErrInvalidRegister, reg)
+	}
+	g.watchpoints = append(g.watchpoints, watchpoint{
+		register: true,
+		location: reg,
+		value:    g.watchedValue(true, reg),
+	})
+	return nil
+}
+
+// ClearWatchpoints removes all watchpoints.
+func (g *Machine) ClearWatchpoints() {
+	g.watchpoints = nil
+}
+
+// checkWatchpoints returns a *Stop for the first watched location changed by
+// the instruction at p, recording the new values of all watched locations.
+func (g *Machine) checkWatchpoints(p Word) *Stop {
+	var stop *Stop
+	for i, w := range g.watchpoints {
+		value := g.watchedValue(w.register, w.location)
+		if value == w.value {
+			continue
+		}
+		if stop == nil {
+			stop = &Stop{
+				Reason:   ErrWatchpoint,
+				P:        g.P,
+				Register: w.register,
+				Location: w.location,
+				Old:      w.value,
+				New:      value,
+				At:       p,
+			}
+		}
+		g.watchpoints[i].value = value
+	}
+	return stop
+}
+
+func (g *Machine) watchedValue(register bool, location Word) Word {
+	if register {
+		return g.register(location)
+	}
+	if !g.validAddress(location) {
+		return Word(0)
+	}
+	return g.Memory[g.MemOffset+location]
+}
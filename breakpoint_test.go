@@ -0,0 +1,194 @@
+package gmachine_test
+
+import (
+	"errors"
+	"testing"
+
+	"gmachine"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestSetBreakpoint_StopsBeforeInstructionAndResumes(t *testing.T) {
+	t.Parallel()
+	g := loadFromString(t, `
+INCA
+.here
+INCA
+INCA
+HALT
+`)
+	g.SetBreakpoint(1)
+	err := g.Run()
+	if !errors.Is(err, gmachine.ErrBreakpoint) {
+		t.Fatalf("want error %v, got %v", gmachine.ErrBreakpoint, err)
+	}
+	var wantP gmachine.Word = 1
+	if wantP != g.P {
+		t.Errorf("want P %d, got %d", wantP, g.P)
+	}
+	var wantA gmachine.Word = 1
+	if wantA != g.A {
+		t.Errorf("want A %d, got %d", wantA, g.A)
+	}
+	err = g.Run()
+	if err != nil {
+		t.Fatal("didn't expect an error resuming from breakpoint:", err)
+	}
+	wantA = 3
+	if wantA != g.A {
+		t.Errorf("want A %d, got %d", wantA, g.A)
+	}
+}
+
+func TestSetBreakpoint_StopsOnEveryLoopIteration(t *testing.T) {
+	t.Parallel()
+	g := loadFromString(t, `
+SETX 3
+.loop
+DECX
+JXNZ loop
+HALT
+`)
+	g.SetBreakpoint(2)
+	stops := 0
+	for {
+		err := g.Run()
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, gmachine.ErrBreakpoint) {
+			t.Fatal("didn't expect an error:", err)
+		}
+		stops++
+	}
+	wantStops := 3
+	if wantStops != stops {
+		t.Errorf("want %d stops, got %d", wantStops, stops)
+	}
+}
+
+func TestClearBreakpoint(t *testing.T) {
+	t.Parallel()
+	g := loadFromString(t, "INCA\nINCA\nHALT")
+	g.SetBreakpoint(1)
+	if !g.Breakpoint(1) {
+		t.Error("want breakpoint at 1")
+	}
+	g.ClearBreakpoint(1)
+	if g.Breakpoint(1) {
+		t.Error("want no breakpoint at 1")
+	}
+	err := g.Run()
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+}
+
+func TestWatchRegister_StopsAfterRegisterChanges(t *testing.T) {
+	t.Parallel()
+	g := loadFromString(t, `
+INCA
+INCY
+SETY 7
+HALT
+`)
+	err := g.WatchRegister(gmachine.RegY)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	err = g.Run()
+	want := gmachine.Stop{
+		Reason:   gmachine.ErrWatchpoint,
+		P:        2,
+		Register: true,
+		Location: gmachine.RegY,
+		Old:      0,
+		New:      1,
+		At:       1,
+	}
+	var stop *gmachine.Stop
+	if !errors.As(err, &stop) {
+		t.Fatalf("want *gmachine.Stop, got %v", err)
+	}
+	if !cmp.Equal(want, *stop, cmpopts.EquateErrors()) {
+		t.Error(cmp.Diff(want, *stop, cmpopts.EquateErrors()))
+	}
+	wantMsg := "watchpoint Y changed from 0 to 1 at P=1"
+	if wantMsg != err.Error() {
+		t.Errorf("want message %q, got %q", wantMsg, err.Error())
+	}
+	err = g.Run()
+	if !errors.Is(err, gmachine.ErrWatchpoint) {
+		t.Fatalf("want error %v, got %v", gmachine.ErrWatchpoint, err)
+	}
+	var wantY gmachine.Word = 7
+	if wantY != g.Y {
+		t.Errorf("want Y %d, got %d", wantY, g.Y)
+	}
+	err = g.Run()
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+}
+
+func TestWatchRegister_ReturnsErrorForInvalidRegister(t *testing.T) {
+	t.Parallel()
+	g := loadFromString(t, "INCA\nHALT")
+	err := g.WatchRegister(3)
+	wantErr := gmachine.ErrInvalidRegister
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("wanted error %v, got %v", wantErr, err)
+	}
+	err = g.Run()
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+}
+
+func TestWatchMemory_StopsAfterWordChanges(t *testing.T) {
+	t.Parallel()
+	g := loadFromString(t, `
+JUMP start
+VARB num 0
+.start
+SETA 42
+MOVE A -> num
+INCA
+HALT
+`)
+	g.WatchMemory(2)
+	err := g.Run()
+	var stop *gmachine.Stop
+	if !errors.As(err, &stop) || stop.Reason != gmachine.ErrWatchpoint {
+		t.Fatalf("want watchpoint stop, got %v", err)
+	}
+	var wantNew gmachine.Word = 42
+	if wantNew != stop.New {
+		t.Errorf("want new value %d, got %d", wantNew, stop.New)
+	}
+	var wantAt gmachine.Word = 5
+	if wantAt != stop.At {
+		t.Errorf("want change at P=%d, got P=%d", wantAt, stop.At)
+	}
+	g.ClearWatchpoints()
+	err = g.Run()
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+}
+
+func loadFromString(t *testing.T, input string) *gmachine.Machine {
+	t.Helper()
+	program, err := assembleFromString(input)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	g := gmachine.New()
+	err = g.Load(program)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	return g
+}
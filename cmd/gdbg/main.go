@@ -0,0 +1,10 @@
+package main
+
+import (
+	"gmachine"
+	"os"
+)
+
+func main() {
+	os.Exit(gmachine.MainDebug())
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"gmachine/disasm"
+	"os"
+)
+
+func main() {
+	os.Exit(disasm.Main())
+}
@@ -6,5 +6,5 @@ import (
 )
 
 func main() {
-	os.Exit(gmachine.RunFile(os.Args[1]))
+	os.Exit(gmachine.MainAssembleAndRun())
 }
@@ -0,0 +1,392 @@
+package gmachine
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listContext is the number of source lines shown either side of the current
+// line by the list command.
+const listContext = 4
+
+// Debugger is an interactive debugger for a program assembled from source.
+type Debugger struct {
+	g      *Machine
+	info   *DebugInfo
+	source []string
+	out    io.Writer
+	// started is set once the program has executed its first instruction.
+	started bool
+	// stopped is set once the program has halted or faulted.
+	stopped bool
+}
+
+// NewDebugger assembles source, loads it into g, and returns a Debugger that
+// writes its output to out.
+func NewDebugger(g *Machine, source string, out io.Writer, opts ...AssembleOption) (*Debugger, error) {
+	program, info, err := AssembleWithDebugInfo(strings.NewReader(source), opts...)
+	if err != nil {
+		return nil, err
+	}
+	err = g.Load(program)
+	if err != nil {
+		return nil, err
+	}
+	if info.HasEntry {
+		g.P = info.Entry
+	}
+	return &Debugger{
+		g:      g,
+		info:   info,
+		source: strings.Split(source, "\n"),
+		out:    out,
+	}, nil
+}
+
+// Run reads commands from in, one per line, until it reaches the end of the
+// input or a quit command.
+func (d *Debugger) Run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(d.out, "(gdbg) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(d.out)
+			return scanner.Err()
+		}
+		if d.Exec(scanner.Text()) {
+			return nil
+		}
+	}
+}
+
+// Exec executes a single debugger command, reporting whether it was a
+// request to quit.
+func (d *Debugger) Exec(line string) (quit bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	cmd, args := fields[0], fields[1:]
+	switch {
+	case cmd == "step" || cmd == "s":
+		d.step()
+	case cmd == "next" || cmd == "n":
+		d.next()
+	case cmd == "continue" || cmd == "c":
+		d.cont()
+	case cmd == "break" || cmd == "b":
+		d.setBreakpoint(args)
+	case cmd == "delete" || cmd == "d":
+		d.clearBreakpoint(args)
+	case cmd == "print" || cmd == "p":
+		d.print(args)
+	case cmd == "x" || strings.HasPrefix(cmd, "x/"):
+		d.examine(cmd, args)
+	case cmd == "regs" || cmd == "r":
+		d.regs()
+	case cmd == "list" || cmd == "l":
+		d.list()
+	case cmd == "help" || cmd == "h":
+		d.help()
+	case cmd == "quit" || cmd == "q":
+		return true
+	default:
+		fmt.Fprintf(d.out, "unknown command %q, try help\n", cmd)
+	}
+	return false
+}
+
+func (d *Debugger) step() {
+	if d.checkStopped() {
+		return
+	}
+	halted, err := d.g.Step()
+	if d.report(halted, err) {
+		return
+	}
+	d.where()
+}
+
+// next executes the next instruction, running any subroutine it calls to
+// completion.
+func (d *Debugger) next() {
+	if d.checkStopped() {
+		return
+	}
+	inst, err := Decode(d.g.Memory[d.g.MemOffset:], d.g.P)
+	if err != nil || inst.Opcode != OpCALL {
+		d.step()
+		return
+	}
+	returnP, depth := d.g.P+inst.Size(), d.g.S
+	for {
+		halted, err := d.g.Step()
+		if d.report(halted, err) {
+			return
+		}
+		if d.g.P == returnP && d.g.S == depth {
+			break
+		}
+		if d.g.Breakpoint(d.g.P) {
+			fmt.Fprintf(d.out, "breakpoint at P=%d\n", d.g.P)
+			break
+		}
+	}
+	d.where()
+}
+
+func (d *Debugger) cont() {
+	if d.checkStopped() {
+		return
+	}
+	// Step off the breakpoint at the current instruction, if any, so that
+	// continuing doesn't stop at it again straight away. A breakpoint on the
+	// very first instruction should still stop the program, though.
+	if d.started && d.g.Breakpoint(d.g.P) {
+		halted, err := d.g.Step()
+		if d.report(halted, err) {
+			return
+		}
+	}
+	err := d.g.Run()
+	d.started = true
+	var stop *Stop
+	if errors.As(err, &stop) {
+		fmt.Fprintln(d.out, stop)
+		d.where()
+		return
+	}
+	d.report(err == nil, err)
+}
+
+// report prints the reason the program stopped, if it did, and reports
+// whether it did.
+func (d *Debugger) report(halted bool, err error) bool {
+	d.started = true
+	switch {
+	case err != nil:
+		fmt.Fprintf(d.out, "program stopped: %v\n", err)
+	case halted:
+		fmt.Fprintln(d.out, "program halted")
+	default:
+		return false
+	}
+	d.stopped = true
+	return true
+}
+
+func (d *Debugger) checkStopped() bool {
+	if d.stopped {
+		fmt.Fprintln(d.out, "the program is not running")
+	}
+	return d.stopped
+}
+
+func (d *Debugger) setBreakpoint(args []string) {
+	addr, ok := d.resolveArg(args)
+	if !ok {
+		return
+	}
+	d.g.SetBreakpoint(addr)
+	fmt.Fprintf(d.out, "breakpoint set at P=%d%s\n", addr, d.lineSuffix(addr))
+}
+
+func (d *Debugger) clearBreakpoint(args []string) {
+	addr, ok := d.resolveArg(args)
+	if !ok {
+		return
+	}
+	d.g.ClearBreakpoint(addr)
+	fmt.Fprintf(d.out, "breakpoint cleared at P=%d\n", addr)
+}
+
+func (d *Debugger) print(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "usage: print REGISTER|SYMBOL")
+		return
+	}
+	regs := d.g.Registers()
+	switch strings.ToUpper(args[0]) {
+	case "P":
+		fmt.Fprintf(d.out, "P = %d\n", regs.P)
+	case "S":
+		fmt.Fprintf(d.out, "S = %d\n", regs.S)
+	case "A":
+		fmt.Fprintf(d.out, "A = %d\n", regs.A)
+	case "X":
+		fmt.Fprintf(d.out, "X = %d\n", regs.X)
+	case "Y":
+		fmt.Fprintf(d.out, "Y = %d\n", regs.Y)
+	case "F":
+		fmt.Fprintf(d.out, "F = %04b\n", regs.F)
+	case "E":
+		fmt.Fprintf(d.out, "E = %d (%s)\n", Word(d.g.E), d.g.E)
+	default:
+		addr, ok := d.resolve(args[0])
+		if !ok {
+			return
+		}
+		fmt.Fprintf(d.out, "%s = %d\n", args[0], addr)
+	}
+}
+
+// examine prints memory, in the style of gdb's x/N command.
+func (d *Debugger) examine(cmd string, args []string) {
+	count := Word(1)
+	if n, ok := strings.CutPrefix(cmd, "x/"); ok {
+		v, err := strconv.ParseUint(n, 0, 64)
+		if err != nil {
+			fmt.Fprintf(d.out, "invalid count %q\n", n)
+			return
+		}
+		count = Word(v)
+	}
+	addr, ok := d.resolveArg(args)
+	if !ok {
+		return
+	}
+	// Stop at the end of memory, however many words were asked for. An
+	// address past the end is shown once, as unknown.
+	if d.g.validAddress(addr) {
+		count = min(count, Word(len(d.g.Memory))-d.g.MemOffset-addr)
+	} else {
+		count = min(count, 1)
+	}
+	for i := Word(0); i < count; i++ {
+		if i%8 == 0 {
+			if i > 0 {
+				fmt.Fprintln(d.out)
+			}
+			fmt.Fprintf(d.out, "%5d:", addr+i)
+		}
+		if !d.g.validAddress(addr + i) {
+			fmt.Fprint(d.out, " ?")
+			continue
+		}
+		fmt.Fprintf(d.out, " %d", d.g.Memory[d.g.MemOffset+addr+i])
+	}
+	fmt.Fprintln(d.out)
+}
+
+func (d *Debugger) regs() {
+	r := d.g.Registers()
+	fmt.Fprintf(d.out, "P=%d S=%d A=%d X=%d Y=%d F=%04b E=%d\n", r.P, r.S, r.A, r.X, r.Y, r.F, Word(d.g.E))
+}
+
+// list prints the source lines around the current instruction.
+func (d *Debugger) list() {
+	line, ok := d.info.Lines[d.g.P]
+	if !ok {
+		fmt.Fprintf(d.out, "no source for P=%d\n", d.g.P)
+		return
+	}
+	breakLines := make(map[int]bool)
+	for addr, l := range d.info.Lines {
+		if d.g.Breakpoint(addr) {
+			breakLines[l] = true
+		}
+	}
+	first := max(line-listContext, 1)
+	last := min(line+listContext, len(d.source))
+	for l := first; l <= last; l++ {
+		marker := "  "
+		if l == line {
+			marker = "=>"
+		}
+		bp := " "
+		if breakLines[l] {
+			bp = "*"
+		}
+		fmt.Fprintf(d.out, "%s%s%4d  %s\n", marker, bp, l, d.source[l-1])
+	}
+}
+
+// where prints the address and source line of the next instruction.
+func (d *Debugger) where() {
+	line, ok := d.info.Lines[d.g.P]
+	if !ok {
+		fmt.Fprintf(d.out, "P=%d\n", d.g.P)
+		return
+	}
+	fmt.Fprintf(d.out, "P=%d line %d: %s\n", d.g.P, line, strings.TrimSpace(d.source[line-1]))
+}
+
+func (d *Debugger) help() {
+	fmt.Fprint(d.out, `commands:
+  step, s                  execute the next instruction
+  next, n                  execute the next instruction, stepping over calls
+  continue, c              run until a breakpoint, HALT or an exception
+  break, b LABEL|ADDR      set a breakpoint
+  delete, d LABEL|ADDR     clear a breakpoint
+  print, p REGISTER|SYMBOL print a register or the address of a symbol
+  x/N LABEL|ADDR           examine N words of memory
+  regs, r                  print all registers
+  list, l                  show the source around the next instruction
+  quit, q                  exit the debugger
+`)
+}
+
+func (d *Debugger) resolveArg(args []string) (Word, bool) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "expected a label or address")
+		return Word(0), false
+	}
+	return d.resolve(args[0])
+}
+
+// resolve returns the address of a label or variable, or of a number.
+func (d *Debugger) resolve(arg string) (Word, bool) {
+	if addr, ok := d.info.Symbols[strings.TrimPrefix(arg, ".")]; ok {
+		return addr, true
+	}
+	v, err := strconv.ParseUint(arg, 0, 64)
+	if err != nil {
+		fmt.Fprintf(d.out, "%s: %v\n", ErrUnknownIdentifier, arg)
+		return Word(0), false
+	}
+	return Word(v), true
+}
+
+func (d *Debugger) lineSuffix(addr Word) string {
+	if line, ok := d.info.Lines[addr]; ok {
+		return fmt.Sprintf(" (line %d)", line)
+	}
+	return ""
+}
+
+func MainDebug() int {
+	fs := flag.NewFlagSet("gdbg", flag.ContinueOnError)
+	err := fs.Parse(os.Args[1:])
+	if err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gdbg FILE")
+		return 1
+	}
+
+	source, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// Standard input carries debugger commands, so the program gets none.
+	g := New(WithOutput(os.Stdout))
+	d, err := NewDebugger(g, string(source), os.Stdout, WithFilename(fs.Arg(0)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = d.Run(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
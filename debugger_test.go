@@ -0,0 +1,159 @@
+package gmachine_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gmachine"
+)
+
+const debuggerSource = `JUMP start
+VARB msg "hi"
+.factorial
+MULA X
+DECX
+JXNZ factorial
+RTRN
+
+.start
+SETA 1
+SETX 3
+CALL factorial
+HALT`
+
+func runDebugger(t *testing.T, commands ...string) (*gmachine.Machine, string) {
+	t.Helper()
+	var out bytes.Buffer
+	g := gmachine.New()
+	d, err := gmachine.NewDebugger(g, debuggerSource, &out)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	err = d.Run(strings.NewReader(strings.Join(commands, "\n")))
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	return g, out.String()
+}
+
+func TestDebugger_BreaksOnLabelAndContinues(t *testing.T) {
+	t.Parallel()
+	g, out := runDebugger(t, "break factorial", "continue", "print A", "print X")
+	for _, want := range []string{
+		"breakpoint set at P=5 (line 4)\n",
+		"breakpoint at P=5\nP=5 line 4: MULA X\n",
+		"A = 1\n",
+		"X = 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want output to contain %q, got:\n%s", want, out)
+		}
+	}
+	var wantP gmachine.Word = 5
+	if wantP != g.P {
+		t.Errorf("want P %d, got %d", wantP, g.P)
+	}
+}
+
+func TestDebugger_NextStepsOverCall(t *testing.T) {
+	t.Parallel()
+	g, out := runDebugger(t, "break .start", "c", "n", "n", "n", "regs")
+	want := "P=17 line 13: HALT\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("want output to contain %q, got:\n%s", want, out)
+	}
+	var wantA gmachine.Word = 6
+	if wantA != g.A {
+		t.Errorf("want A %d, got %d", wantA, g.A)
+	}
+}
+
+func TestDebugger_PrintsEachFlagBit(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	d, err := gmachine.NewDebugger(gmachine.New(), "SETA 1\nCMPA 2\nHALT", &out)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	err = d.Run(strings.NewReader("s\ns\np F\nregs"))
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	for _, want := range []string{"F = 0110\n", " F=0110 "} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("want output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestDebugger_StepEntersCall(t *testing.T) {
+	t.Parallel()
+	_, out := runDebugger(t, "b 15", "c", "step")
+	want := "P=5 line 4: MULA X\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("want output to contain %q, got:\n%s", want, out)
+	}
+}
+
+func TestDebugger_ExaminesMemory(t *testing.T) {
+	t.Parallel()
+	_, out := runDebugger(t, "x/3 msg")
+	want := "    2: 104 105 0\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("want output to contain %q, got:\n%s", want, out)
+	}
+}
+
+func TestDebugger_StopsExaminingAtEndOfMemory(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"x/18446744073709551615 764", "  764: 0 0 0 0\n(gdbg) \n"},
+		{"x/5 768", "  768: ?\n(gdbg) \n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			_, out := runDebugger(t, tt.command)
+			if !strings.HasSuffix(out, tt.want) {
+				t.Errorf("want output to end with %q, got:\n%s", tt.want, out)
+			}
+		})
+	}
+}
+
+func TestDebugger_ListsSourceAroundCurrentLine(t *testing.T) {
+	t.Parallel()
+	_, out := runDebugger(t, "break factorial", "c", "list")
+	want := "" +
+		"      1  JUMP start\n" +
+		"      2  VARB msg \"hi\"\n" +
+		"      3  .factorial\n" +
+		"=>*   4  MULA X\n" +
+		"      5  DECX\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("want output to contain %q, got:\n%s", want, out)
+	}
+}
+
+func TestDebugger_ReportsHaltAndRefusesToContinue(t *testing.T) {
+	t.Parallel()
+	_, out := runDebugger(t, "c", "s")
+	for _, want := range []string{"program halted\n", "the program is not running\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestDebugger_StopsReadingAtQuit(t *testing.T) {
+	t.Parallel()
+	g, _ := runDebugger(t, "q", "c")
+	// The program is still at its entry point.
+	var wantP gmachine.Word = 11
+	if wantP != g.P {
+		t.Errorf("want P %d, got %d", wantP, g.P)
+	}
+}
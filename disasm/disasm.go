@@ -0,0 +1,171 @@
+// Package disasm turns G-machine programs back into readable assembly.
+package disasm
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gmachine"
+)
+
+// Line is a single line of a disassembly listing: either a decoded
+// instruction, or a word of data that isn't reached as code.
+type Line struct {
+	Addr        gmachine.Word
+	Words       []gmachine.Word
+	Label       string
+	Instruction gmachine.Instruction
+	Data        bool
+	// target is the label of the address operand, if it has one.
+	target string
+}
+
+// Text returns the line in assembler syntax, with jump targets written as
+// labels where the listing has them.
+func (l Line) Text() string {
+	switch {
+	case l.Data:
+		return fmt.Sprintf("DATA %d", l.Words[0])
+	case l.target != "":
+		return fmt.Sprintf("%s %s", l.Instruction.Mnemonic(), l.target)
+	default:
+		return l.Instruction.String()
+	}
+}
+
+// String returns the line as it appears in a listing: its address, the words
+// it occupies in hex, and its text.
+func (l Line) String() string {
+	words := make([]string, len(l.Words))
+	for i, w := range l.Words {
+		words[i] = fmt.Sprintf("%02x", w)
+	}
+	return fmt.Sprintf("%5d  %-12s  %s", l.Addr, strings.Join(words, " "), l.Text())
+}
+
+// Disassemble decodes program into a listing. Code is found by following
+// the flow of control from entry, so that data stored among the
+// instructions isn't mistaken for code; every word that isn't reached is
+// listed as data. Each jump or call target is given a label of the form
+// L0012, where 12 is its address, and a non-zero entry point is labelled
+// gmachine.EntryLabel.
+func Disassemble(program []gmachine.Word, entry gmachine.Word) []Line {
+	insts := map[gmachine.Word]gmachine.Instruction{}
+	code := make([]bool, len(program))
+	work := []gmachine.Word{entry}
+	for len(work) > 0 {
+		addr := work[len(work)-1]
+		work = work[:len(work)-1]
+		for addr < gmachine.Word(len(program)) && !code[addr] {
+			inst, err := gmachine.Decode(program, addr)
+			if err != nil {
+				break
+			}
+			insts[addr] = inst
+			for i := gmachine.Word(0); i < inst.Size(); i++ {
+				code[addr+i] = true
+			}
+			if inst.Kind == gmachine.OperandAddress {
+				work = append(work, inst.Operand)
+			}
+			if inst.Opcode == gmachine.OpHALT || inst.Opcode == gmachine.OpJUMP || inst.Opcode == gmachine.OpRTRN {
+				break
+			}
+			addr += inst.Size()
+		}
+	}
+
+	var lines []Line
+	for addr := gmachine.Word(0); addr < gmachine.Word(len(program)); {
+		inst, ok := insts[addr]
+		if !ok {
+			lines = append(lines, Line{Addr: addr, Words: program[addr : addr+1], Data: true})
+			addr++
+			continue
+		}
+		lines = append(lines, Line{Addr: addr, Words: program[addr : addr+inst.Size()], Instruction: inst})
+		addr += inst.Size()
+	}
+
+	// Only targets that start a line can be labelled; anything else, such
+	// as a jump into the middle of an instruction, is left as a number.
+	index := make(map[gmachine.Word]int, len(lines))
+	for i, l := range lines {
+		index[l.Addr] = i
+		if entry != 0 && l.Addr == entry {
+			lines[i].Label = gmachine.EntryLabel
+		}
+	}
+	for i, l := range lines {
+		if l.Data || l.Instruction.Kind != gmachine.OperandAddress {
+			continue
+		}
+		j, ok := index[l.Instruction.Operand]
+		if !ok {
+			continue
+		}
+		if lines[j].Label == "" {
+			lines[j].Label = label(l.Instruction.Operand)
+		}
+		lines[i].target = lines[j].Label
+	}
+	return lines
+}
+
+func label(addr gmachine.Word) string {
+	return fmt.Sprintf("L%04d", addr)
+}
+
+// Fprint writes the listing of exe to w, with each label on its own line
+// before the instruction it marks.
+func Fprint(w io.Writer, exe *gmachine.Executable) error {
+	for _, l := range Disassemble(exe.Image(), exe.Entry) {
+		if l.Label != "" {
+			_, err := fmt.Fprintf(w, ".%s\n", l.Label)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintln(w, l)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Main() int {
+	fs := flag.NewFlagSet("gdis", flag.ContinueOnError)
+	raw := fs.Bool("raw", false, "read FILE as a legacy raw image with no header")
+	err := fs.Parse(os.Args[1:])
+	if err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gdis [--raw] FILE")
+		return 1
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+
+	exe, err := gmachine.ReadFile(f, *raw)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = Fprint(os.Stdout, exe)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
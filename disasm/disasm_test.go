@@ -0,0 +1,127 @@
+package disasm_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gmachine"
+	"gmachine/disasm"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func assemble(t *testing.T, input string) []gmachine.Word {
+	t.Helper()
+	program, err := gmachine.Assemble(strings.NewReader(input))
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	return program
+}
+
+func TestDisassemble_DecodesInstructionsWithOperands(t *testing.T) {
+	t.Parallel()
+	program := assemble(t, "SETA 42\nADDA X\nCMPA 5\nMOVE A -> n\nHALT\nVARB n 0")
+	var got []string
+	for _, l := range disasm.Disassemble(program, 0) {
+		got = append(got, l.Text())
+	}
+	want := []string{"SETA 42", "ADDA X", "CMPA 5", "MOVE A -> 9", "HALT", "DATA 0"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestDisassemble_RecordsAddressAndWordsOfEachLine(t *testing.T) {
+	t.Parallel()
+	program := assemble(t, "INCA\nSETX 3\nHALT")
+	lines := disasm.Disassemble(program, 0)
+	want := []gmachine.Word{gmachine.OpSETX, 3}
+	if !cmp.Equal(want, lines[1].Words) {
+		t.Error(cmp.Diff(want, lines[1].Words))
+	}
+	var wantAddr gmachine.Word = 1
+	if wantAddr != lines[1].Addr {
+		t.Errorf("want address %d, got %d", wantAddr, lines[1].Addr)
+	}
+}
+
+func TestDisassemble_LabelsJumpTargets(t *testing.T) {
+	t.Parallel()
+	program := assemble(t, "SETX 3\n.loop\nINCA\nJXNZ loop\nHALT")
+	lines := disasm.Disassemble(program, 0)
+	if lines[1].Label != "L0002" {
+		t.Errorf("want label %q, got %q", "L0002", lines[1].Label)
+	}
+	want := "JXNZ L0002"
+	got := lines[2].Text()
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestDisassemble_ListsUnreachedWordsAsData(t *testing.T) {
+	t.Parallel()
+	// The variable's value is a valid opcode, but is never executed.
+	program := assemble(t, "JUMP start\nVARB n 17\n.start\nHALT")
+	var got []string
+	for _, l := range disasm.Disassemble(program, 0) {
+		got = append(got, l.Text())
+	}
+	want := []string{"JUMP L0003", "DATA 17", "HALT"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestDisassemble_LeavesTargetOutsideProgramAsNumber(t *testing.T) {
+	t.Parallel()
+	program := []gmachine.Word{gmachine.OpJUMP, 100}
+	lines := disasm.Disassemble(program, 0)
+	want := "JUMP 100"
+	got := lines[0].Text()
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestFprint_WritesAnnotatedListing(t *testing.T) {
+	t.Parallel()
+	program := assemble(t, "SETX 3\n.loop\nINCA\nJXNZ loop\nHALT")
+	var buf bytes.Buffer
+	err := disasm.Fprint(&buf, &gmachine.Executable{Code: program})
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	want := "" +
+		"    0  12 03         SETX 3\n" +
+		".L0002\n" +
+		"    2  04            INCA\n" +
+		"    3  17 02         JXNZ L0002\n" +
+		"    5  01            HALT\n"
+	got := buf.String()
+	if want != got {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestDisassemble_FollowsCodeFromEntryPoint(t *testing.T) {
+	t.Parallel()
+	exe, err := gmachine.AssembleExecutable(strings.NewReader("VARB n 17\n.start\nJUMP start"))
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	lines := disasm.Disassemble(exe.Image(), exe.Entry)
+	var got []string
+	for _, l := range lines {
+		got = append(got, l.Text())
+	}
+	want := []string{"DATA 17", "JUMP start"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if lines[1].Label != gmachine.EntryLabel {
+		t.Errorf("want label %q, got %q", gmachine.EntryLabel, lines[1].Label)
+	}
+}
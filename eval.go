@@ -0,0 +1,166 @@
+package gmachine
+
+import (
+	"errors"
+	"fmt"
+	"gmachine/ast"
+	"gmachine/lexer"
+)
+
+var ErrDivisionByZero error = errors.New("division by zero")
+var ErrCircularDefinition error = errors.New("circular definition")
+
+// errReported stands in for a problem with a constant's definition, which
+// is reported once, where the constant is defined, rather than everywhere
+// it's used.
+var errReported error = errors.New("error already reported")
+
+// constant is a CONS definition. Its value is worked out when it's first
+// needed, so that it can refer to labels defined after it.
+type constant struct {
+	value     ast.Expression
+	here      Word
+	expansion *expansion // the macro call the definition came from, if any
+	result    Word
+	err       error
+	evaluated bool
+	// evaluating is set while the constant's value is being worked out, to
+	// catch constants defined in terms of themselves.
+	evaluating bool
+}
+
+// evaluate returns the value of expr, an operand of the statement at
+// address here, which is the value of $. Arithmetic wraps around, and
+// division and shifts are unsigned, as they are for the machine's own
+// instructions.
+func (t *symbolTable) evaluate(expr ast.Expression, here Word) (Word, error) {
+	switch expr := expr.(type) {
+	case nil:
+		// The statement is incomplete, and its syntax error has already
+		// been reported.
+		return 0, errReported
+	case ast.IntegerLiteral:
+		return Word(expr.Value), nil
+	case ast.CharacterLiteral:
+		return Word(expr.Value), nil
+	case ast.CurrentAddress:
+		return here, nil
+	case ast.Identifier:
+		return t.value(expr)
+	case ast.CallExpression:
+		return t.call(expr)
+	case ast.PrefixExpression:
+		right, err := t.evaluate(expr.Right, here)
+		if err != nil {
+			return 0, err
+		}
+		switch expr.Operator {
+		case "-":
+			return -right, nil
+		case "~":
+			return ^right, nil
+		}
+	case ast.InfixExpression:
+		left, err := t.evaluate(expr.Left, here)
+		if err != nil {
+			return 0, err
+		}
+		right, err := t.evaluate(expr.Right, here)
+		if err != nil {
+			return 0, err
+		}
+		switch expr.Operator {
+		case "+":
+			return left + right, nil
+		case "-":
+			return left - right, nil
+		case "*":
+			return left * right, nil
+		case "/", "%":
+			if right == 0 {
+				return 0, &lexer.Error{Pos: expr.Right.Pos(), Err: ErrDivisionByZero}
+			}
+			if expr.Operator == "/" {
+				return left / right, nil
+			}
+			return left % right, nil
+		case "<<":
+			return left << right, nil
+		case ">>":
+			return left >> right, nil
+		case "&":
+			return left & right, nil
+		case "|":
+			return left | right, nil
+		}
+	}
+	return 0, errorAt(expr, ErrInvalidOperand, expr.TokenLiteral())
+}
+
+// value returns the value of the label, constant or variable named by
+// ident.
+func (t *symbolTable) value(ident ast.Identifier) (Word, error) {
+	name := ident.Value
+	if address, ok := t.labels[name]; ok {
+		return address, nil
+	}
+	if c, ok := t.consts[name]; ok {
+		if c.evaluating {
+			return 0, errorAt(ident, ErrCircularDefinition, name)
+		}
+		t.evaluateConstant(c)
+		if c.err != nil {
+			return 0, errReported
+		}
+		return c.result, nil
+	}
+	if address, ok := t.variables[name]; ok {
+		return address, nil
+	}
+	return 0, errorAt(ident, ErrUnknownIdentifier, name)
+}
+
+func (t *symbolTable) evaluateConstant(c *constant) {
+	if c.evaluated {
+		return
+	}
+	c.evaluating = true
+	c.result, c.err = t.evaluate(c.value, c.here)
+	c.evaluating = false
+	c.evaluated = true
+}
+
+// constantErrors evaluates every constant, and returns the problems with
+// their definitions.
+func (t *symbolTable) constantErrors() lexer.ErrorList {
+	var errs lexer.ErrorList
+	for _, c := range t.consts {
+		t.evaluateConstant(c)
+		var e *lexer.Error
+		if errors.As(c.expansion.wrap(c.err), &e) {
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+// call returns the result of a call to a built-in function. The only one is
+// len, which gives the number of characters in a string variable, not
+// counting the terminating zero, or 1 for an integer variable.
+func (t *symbolTable) call(expr ast.CallExpression) (Word, error) {
+	if expr.Function.Value != "len" {
+		return 0, errorAt(expr.Function, ErrInvalidOperand, "unknown function "+expr.Function.Value)
+	}
+	ident, ok := expr.Argument.(ast.Identifier)
+	if !ok {
+		return 0, errorAt(expr.Argument, ErrInvalidOperand, "len needs the name of a variable")
+	}
+	length, ok := t.lengths[ident.Value]
+	if ok {
+		return length, nil
+	}
+	if _, err := t.value(ident); err != nil {
+		return 0, err
+	}
+	return 0, errorAt(ident, ErrInvalidOperand, fmt.Sprintf("len needs the name of a variable, but %s is not one", ident.Value))
+}
@@ -1,15 +1,10 @@
 .add
-POPA
-MOVA X
-POPA
 ADDA X
-PSHA
-RTRN ; sets P to R
+RTRN ; pops the return address into P
 
 .start
 SETA 4
-PSHA
-SETA 6
-PSHA
-CALL .add ; CALL := SETR P, JUMP .add
-OUTA
\ No newline at end of file
+SETX 6
+CALL add ; pushes the return address and jumps to .add
+OUTA
+HALT
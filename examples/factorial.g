@@ -3,7 +3,7 @@
 ; 2. Implement SETX - done
 ; 3. Implement DECX (and DECA, DECY) - done
 ; 4. Implement JXNZ - done
-; 5. Implement CALL and RTRN
+; 5. Implement CALL and RTRN - done
 
 .factorial
 MULA X
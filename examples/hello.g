@@ -1,26 +1,19 @@
 ; prints "hello world!"
-SETA 'h'
-OUTA
-SETA 'e'
-OUTA
-SETA 'l'
-OUTA
-SETA 'l'
-OUTA
-SETA 'o'
-OUTA
-SETA ' '
-OUTA
-SETA 'w'
-OUTA
-SETA 'o'
-OUTA
-SETA 'r'
-OUTA
-SETA 'l'
-OUTA
-SETA 'd'
-OUTA
-SETA '!'
-OUTA
-HALT
\ No newline at end of file
+MACRO putc c
+SETA c
+OUTC
+ENDM
+
+putc 'h'
+putc 'e'
+putc 'l'
+putc 'l'
+putc 'o'
+putc ' '
+putc 'w'
+putc 'o'
+putc 'r'
+putc 'l'
+putc 'd'
+putc '!'
+HALT
@@ -0,0 +1,138 @@
+package gmachine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// ExecutableMagic identifies a file in the G executable format.
+const ExecutableMagic = "GEXE"
+
+// ExecutableVersion is the version of the executable format written by
+// Compile. ReadExecutable rejects any other version.
+const ExecutableVersion = 1
+
+var ErrNotExecutable error = errors.New("not a G executable")
+var ErrUnsupportedVersion error = errors.New("unsupported executable version")
+var ErrInvalidExecutable error = errors.New("invalid executable")
+var ErrChecksumMismatch error = errors.New("checksum mismatch")
+
+// header is the fixed-size header at the start of an executable, written in
+// big-endian byte order. It is followed by the code segment and then the
+// data segment, each as big-endian words.
+type header struct {
+	Magic    [4]byte
+	Version  uint32
+	Entry    Word
+	CodeSize Word
+	DataSize Word
+	// Checksum is the CRC-32 (IEEE) of the code and data segments.
+	Checksum uint32
+}
+
+// Executable is a program in the G executable format. When loaded, the data
+// segment follows the code segment in memory, and execution begins at Entry
+// if HasEntry is set. Otherwise it begins wherever P already is. An
+// executable read from a file always has an entry point, since the header
+// records one.
+type Executable struct {
+	Entry    Word
+	HasEntry bool
+	Code     []Word
+	Data     []Word
+}
+
+// Image returns the program as it is laid out in memory.
+func (e *Executable) Image() []Word {
+	image := make([]Word, 0, len(e.Code)+len(e.Data))
+	image = append(image, e.Code...)
+	return append(image, e.Data...)
+}
+
+// WriteTo writes e to w in the executable format.
+func (e *Executable) WriteTo(w io.Writer) (int64, error) {
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, e.Image())
+	h := header{
+		Version:  ExecutableVersion,
+		Entry:    e.Entry,
+		CodeSize: Word(len(e.Code)),
+		DataSize: Word(len(e.Data)),
+		Checksum: crc32.ChecksumIEEE(body.Bytes()),
+	}
+	copy(h.Magic[:], ExecutableMagic)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, h)
+	buf.Write(body.Bytes())
+	return buf.WriteTo(w)
+}
+
+// ReadExecutable reads a program in the executable format from r, checking
+// its header and checksum.
+func ReadExecutable(r io.Reader) (*Executable, error) {
+	var h header
+	err := binary.Read(r, binary.BigEndian, &h)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: file too short", ErrNotExecutable)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if string(h.Magic[:]) != ExecutableMagic {
+		return nil, fmt.Errorf("%w: bad magic %q", ErrNotExecutable, h.Magic[:])
+	}
+	if h.Version != ExecutableVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, h.Version)
+	}
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	size := Word(len(body) / 8)
+	if len(body)%8 != 0 || h.CodeSize > size || h.DataSize != size-h.CodeSize {
+		return nil, fmt.Errorf("%w: segments of %d and %d words don't match %d bytes of content", ErrInvalidExecutable, h.CodeSize, h.DataSize, len(body))
+	}
+	if h.Entry > 0 && h.Entry >= h.CodeSize {
+		return nil, fmt.Errorf("%w: entry point %d outside code segment of %d words", ErrInvalidExecutable, h.Entry, h.CodeSize)
+	}
+	if sum := crc32.ChecksumIEEE(body); sum != h.Checksum {
+		return nil, fmt.Errorf("%w: want %#08x, got %#08x", ErrChecksumMismatch, h.Checksum, sum)
+	}
+
+	image := make([]Word, size)
+	binary.Read(bytes.NewReader(body), binary.BigEndian, image)
+	return &Executable{
+		Entry:    h.Entry,
+		HasEntry: true,
+		Code:     image[:h.CodeSize],
+		Data:     image[h.CodeSize:],
+	}, nil
+}
+
+// LoadExecutable loads the image of exe into memory and sets P to its entry
+// point, if it has one.
+func (g *Machine) LoadExecutable(exe *Executable) error {
+	err := g.Load(exe.Image())
+	if err != nil {
+		return err
+	}
+	if exe.HasEntry {
+		g.P = exe.Entry
+	}
+	return nil
+}
+
+// RunExecutable loads exe and runs it from its entry point.
+func (g *Machine) RunExecutable(exe *Executable) error {
+	err := g.LoadExecutable(exe)
+	if err != nil {
+		return err
+	}
+	return g.Run()
+}
@@ -0,0 +1,218 @@
+package gmachine_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"gmachine"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeExecutable(t *testing.T, exe *gmachine.Executable) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	_, err := exe.WriteTo(&buf)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExecutable_RoundTripsThroughWriteToAndReadExecutable(t *testing.T) {
+	t.Parallel()
+	want := &gmachine.Executable{
+		Entry:    2,
+		HasEntry: true,
+		Code:     []gmachine.Word{gmachine.OpHALT, 0, gmachine.OpSETA, 42, gmachine.OpHALT},
+		Data:     []gmachine.Word{1, 2, 3},
+	}
+	got, err := gmachine.ReadExecutable(bytes.NewReader(writeExecutable(t, want)))
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestExecutable_WritesHeaderBeforeSegments(t *testing.T) {
+	t.Parallel()
+	data := writeExecutable(t, &gmachine.Executable{
+		Code: []gmachine.Word{gmachine.OpHALT},
+	})
+	want := []byte{
+		'G', 'E', 'X', 'E',
+		0, 0, 0, 1, // version
+		0, 0, 0, 0, 0, 0, 0, 0, // entry
+		0, 0, 0, 0, 0, 0, 0, 1, // code size
+		0, 0, 0, 0, 0, 0, 0, 0, // data size
+		0x12, 0x25, 0xef, 0xff, // checksum
+		0, 0, 0, 0, 0, 0, 0, byte(gmachine.OpHALT),
+	}
+	if !cmp.Equal(want, data) {
+		t.Error(cmp.Diff(want, data))
+	}
+}
+
+func TestReadExecutable_RejectsInvalidFiles(t *testing.T) {
+	t.Parallel()
+	valid := func() []byte {
+		return writeExecutable(t, &gmachine.Executable{
+			Code: []gmachine.Word{gmachine.OpSETA, 42, gmachine.OpHALT},
+			Data: []gmachine.Word{7},
+		})
+	}
+	tests := []struct {
+		description string
+		data        func() []byte
+		wantErr     error
+	}{
+		{
+			description: "empty file",
+			data:        func() []byte { return nil },
+			wantErr:     gmachine.ErrNotExecutable,
+		},
+		{
+			description: "legacy raw image",
+			data:        func() []byte { return valid()[36:] },
+			wantErr:     gmachine.ErrNotExecutable,
+		},
+		{
+			description: "unknown version",
+			data: func() []byte {
+				data := valid()
+				data[7] = 2
+				return data
+			},
+			wantErr: gmachine.ErrUnsupportedVersion,
+		},
+		{
+			description: "truncated segment",
+			data:        func() []byte { d := valid(); return d[:len(d)-8] },
+			wantErr:     gmachine.ErrInvalidExecutable,
+		},
+		{
+			description: "partial word",
+			data:        func() []byte { return append(valid(), 0) },
+			wantErr:     gmachine.ErrInvalidExecutable,
+		},
+		{
+			description: "entry point outside code segment",
+			data: func() []byte {
+				data := valid()
+				data[15] = 3
+				return data
+			},
+			wantErr: gmachine.ErrInvalidExecutable,
+		},
+		{
+			description: "corrupted content",
+			data: func() []byte {
+				data := valid()
+				data[len(data)-1] = 8
+				return data
+			},
+			wantErr: gmachine.ErrChecksumMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			_, err := gmachine.ReadExecutable(bytes.NewReader(tt.data()))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("wanted error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadExecutable_StartsAtEntryPoint(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.LoadExecutable(&gmachine.Executable{
+		Entry:    3,
+		HasEntry: true,
+		Code:     []gmachine.Word{gmachine.OpSETA, 1, gmachine.OpHALT, gmachine.OpSETA, 2, gmachine.OpHALT},
+	})
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	err = g.Run()
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var wantA gmachine.Word = 2
+	if wantA != g.A {
+		t.Errorf("want A %d, got %d", wantA, g.A)
+	}
+}
+
+func TestLoadExecutable_LeavesPWithoutEntryPoint(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	g.P = 3
+	err := g.LoadExecutable(&gmachine.Executable{
+		Code: []gmachine.Word{gmachine.OpSETA, 1, gmachine.OpHALT, gmachine.OpSETA, 2, gmachine.OpHALT},
+	})
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var wantP gmachine.Word = 3
+	if wantP != g.P {
+		t.Errorf("want P %d, got %d", wantP, g.P)
+	}
+}
+
+func TestReadFile_TreatsRawImageAsCodeSegment(t *testing.T) {
+	t.Parallel()
+	data := []byte{
+		0, 0, 0, 0, 0, 0, 0, byte(gmachine.OpSETA),
+		0, 0, 0, 0, 0, 0, 0, 42,
+	}
+	got, err := gmachine.ReadFile(bytes.NewReader(data), true)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	want := &gmachine.Executable{Code: []gmachine.Word{gmachine.OpSETA, 42}}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestReadFile_RunsLegacyRawImageOnlyWhenAskedFor(t *testing.T) {
+	t.Parallel()
+	var image bytes.Buffer
+	binary.Write(&image, binary.BigEndian, []gmachine.Word{
+		gmachine.OpSETA, 42,
+		gmachine.OpHALT,
+	})
+	_, err := gmachine.ReadFile(bytes.NewReader(image.Bytes()), false)
+	wantErr := gmachine.ErrNotExecutable
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("wanted error %v, got %v", wantErr, err)
+	}
+	exe, err := gmachine.ReadFile(bytes.NewReader(image.Bytes()), true)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	g := gmachine.New()
+	err = g.RunExecutable(exe)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var wantA gmachine.Word = 42
+	if wantA != g.A {
+		t.Errorf("want A %d, got %d", wantA, g.A)
+	}
+}
+
+func TestReadFile_RejectsRawImageWithPartialWord(t *testing.T) {
+	t.Parallel()
+	_, err := gmachine.ReadFile(bytes.NewReader([]byte{0, 0, 0}), true)
+	wantErr := gmachine.ErrInvalidExecutable
+	if !errors.Is(err, wantErr) {
+		t.Errorf("wanted error %v, got %v", wantErr, err)
+	}
+}
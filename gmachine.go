@@ -3,17 +3,26 @@ package gmachine
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
+	"flag"
 	"fmt"
 	"gmachine/ast"
 	"gmachine/lexer"
 	"gmachine/parser"
+	"gmachine/token"
 	"io"
+	"math/bits"
 	"os"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+// MemSize and StackSize are the default sizes, in words, of a Machine's memory
+// and of the stack region at the bottom of it.
 const MemSize = 1024
 const StackSize = 256
 
@@ -41,6 +50,44 @@ const (
 	OpPOPA
 	OpJUMP
 	OpJXNZ
+	OpCALL
+	OpRTRN
+	OpCMPA
+	OpCMPAI
+	OpJZ
+	OpJNZ
+	OpJEQ
+	OpJLT
+	OpJGT
+	OpJANZ
+	OpJAZ
+	OpJYNZ
+	OpSUBA
+	OpSUBAI
+	OpDIVA
+	OpDIVAI
+	OpMODA
+	OpMODAI
+	OpANDA
+	OpANDAI
+	OpORA
+	OpORAI
+	OpXORA
+	OpXORAI
+	OpNOTA
+	OpSHLA
+	OpSHLAI
+	OpSHRA
+	OpSHRAI
+	OpROLA
+	OpROLAI
+	OpRORA
+	OpRORAI
+	OpINA
+	OpOUTC
+	OpOUTN
+	OpJSLT
+	OpJSGT
 )
 
 const (
@@ -49,18 +96,102 @@ const (
 	RegY
 )
 
+// EOF is the value INA loads into the A register once the input is exhausted.
+const EOF Word = 1<<64 - 1
+
+// The bits of the flags register, set by CMPA from the result of subtracting
+// its operand from the A register. FlagCarry is set when A is less than the
+// operand treating both as unsigned, which JLT and JGT test. FlagNegative is
+// the sign bit of the result, and FlagOverflow is set when the result's sign
+// is wrong because the subtraction overflowed, so that A is less than the
+// operand treating both as signed when exactly one of them is set, which
+// JSLT and JSGT test.
+const (
+	FlagZero Word = 1 << iota
+	FlagCarry
+	FlagNegative
+	FlagOverflow
+)
+
+// ExceptionKind identifies the kind of fault recorded in Machine.E. Each kind
+// is also an error, so an *Exception can be matched with errors.Is.
+type ExceptionKind Word
+
 const (
-	ExceptionOK Word = iota
+	ExceptionOK ExceptionKind = iota
 	ExceptionIllegalInstruction
 	ExceptionOutOfMemory
+	ExceptionDivideByZero
+	ExceptionBudgetExhausted
+	ExceptionStackOverflow
+	ExceptionStackUnderflow
+	ExceptionInvalidAddress
 )
 
+var exceptionMessages = map[ExceptionKind]string{
+	ExceptionOK:                 "ok",
+	ExceptionIllegalInstruction: "illegal instruction",
+	ExceptionOutOfMemory:        "out of memory",
+	ExceptionDivideByZero:       "divide by zero",
+	ExceptionBudgetExhausted:    "instruction budget exhausted",
+	ExceptionStackOverflow:      "stack overflow",
+	ExceptionStackUnderflow:     "stack underflow",
+	ExceptionInvalidAddress:     "invalid address",
+}
+
+func (k ExceptionKind) Error() string {
+	if msg, ok := exceptionMessages[k]; ok {
+		return msg
+	}
+	return fmt.Sprintf("exception %d", Word(k))
+}
+
+// Registers is a snapshot of the machine's registers.
+type Registers struct {
+	P Word
+	S Word
+	A Word
+	X Word
+	Y Word
+	F Word
+}
+
+// Exception is the error returned by Run when the machine faults.
+type Exception struct {
+	Kind ExceptionKind
+	// P is the address of the faulting instruction.
+	P      Word
+	Opcode Word
+	// Operand is the offending value, if any, such as an invalid address.
+	Operand   Word
+	Registers Registers
+}
+
+func (e *Exception) Error() string {
+	switch e.Kind {
+	case ExceptionIllegalInstruction:
+		return fmt.Sprintf("%s %#x at P=%d", e.Kind, e.Opcode, e.P)
+	case ExceptionOutOfMemory, ExceptionBudgetExhausted:
+		return fmt.Sprintf("%s at P=%d", e.Kind, e.P)
+	case ExceptionInvalidAddress:
+		return fmt.Sprintf("%s %#x at P=%d (%s)", e.Kind, e.Operand, e.P, mnemonic(e.Opcode))
+	default:
+		return fmt.Sprintf("%s at P=%d (%s)", e.Kind, e.P, mnemonic(e.Opcode))
+	}
+}
+
+func (e *Exception) Unwrap() error {
+	return e.Kind
+}
+
 var ErrInvalidOperand error = errors.New("invalid operand")
 var ErrUnknownIdentifier error = errors.New("missing label")
 var ErrInvalidNumber error = errors.New("invalid number")
 var ErrInvalidRegister error = errors.New("invalid register")
 var ErrUndefinedInstruction error = errors.New("undefined instruction")
 var ErrUnknownOpcode error = errors.New("unknown opcode")
+var ErrSymbolRedefined error = errors.New("symbol already defined")
+var ErrInvalidEntryPoint error = errors.New("invalid entry point")
 
 var registers = map[string]Word{
 	"A": RegA,
@@ -92,6 +223,44 @@ var opcodes = map[string]Word{
 	"POPA":  OpPOPA,
 	"JUMP":  OpJUMP,
 	"JXNZ":  OpJXNZ,
+	"CALL":  OpCALL,
+	"RTRN":  OpRTRN,
+	"CMPA":  OpCMPA,
+	"CMPAI": OpCMPAI,
+	"JZ":    OpJZ,
+	"JNZ":   OpJNZ,
+	"JEQ":   OpJEQ,
+	"JLT":   OpJLT,
+	"JGT":   OpJGT,
+	"JSLT":  OpJSLT,
+	"JSGT":  OpJSGT,
+	"JANZ":  OpJANZ,
+	"JAZ":   OpJAZ,
+	"JYNZ":  OpJYNZ,
+	"SUBA":  OpSUBA,
+	"SUBAI": OpSUBAI,
+	"DIVA":  OpDIVA,
+	"DIVAI": OpDIVAI,
+	"MODA":  OpMODA,
+	"MODAI": OpMODAI,
+	"ANDA":  OpANDA,
+	"ANDAI": OpANDAI,
+	"ORA":   OpORA,
+	"ORAI":  OpORAI,
+	"XORA":  OpXORA,
+	"XORAI": OpXORAI,
+	"NOTA":  OpNOTA,
+	"SHLA":  OpSHLA,
+	"SHLAI": OpSHLAI,
+	"SHRA":  OpSHRA,
+	"SHRAI": OpSHRAI,
+	"ROLA":  OpROLA,
+	"ROLAI": OpROLAI,
+	"RORA":  OpRORA,
+	"RORAI": OpRORAI,
+	"INA":   OpINA,
+	"OUTC":  OpOUTC,
+	"OUTN":  OpOUTN,
 }
 
 type Word uint64
@@ -102,238 +271,803 @@ type Machine struct {
 	A         Word
 	X         Word
 	Y         Word
-	E         Word
+	E         ExceptionKind
+	F         Word
+	In        io.Reader
 	Out       io.Writer
+	Tracer    Tracer
 	MemOffset Word
 	Memory    []Word
+
+	// InstructionLimit is the maximum number of instructions the machine
+	// will execute before raising ExceptionBudgetExhausted. Zero means no
+	// limit.
+	InstructionLimit Word
+	executed         Word
+
+	// operand is the offending value recorded by the last raise.
+	operand Word
+
+	breakpoints map[Word]bool
+	watchpoints []watchpoint
+	// resumeP is the breakpoint address the machine last stopped at, which
+	// is stepped over when it is resumed.
+	resumeP  Word
+	resuming bool
+}
+
+// Option configures a Machine created by New.
+type Option func(*Machine)
+
+// WithMemorySize sets the total number of words of memory, including the
+// stack.
+func WithMemorySize(size Word) Option {
+	return func(g *Machine) {
+		g.Memory = make([]Word, size)
+	}
+}
+
+// WithStackSize sets the number of words reserved for the stack at the bottom
+// of memory. Programs are loaded immediately above it.
+func WithStackSize(size Word) Option {
+	return func(g *Machine) {
+		g.MemOffset = size
+	}
+}
+
+// WithInput sets the reader INA takes its input from.
+func WithInput(in io.Reader) Option {
+	return func(g *Machine) {
+		g.In = in
+	}
+}
+
+// WithOutput sets the writer the output instructions write to.
+func WithOutput(out io.Writer) Option {
+	return func(g *Machine) {
+		g.Out = out
+	}
+}
+
+// WithTracer sets the Tracer that receives a record of each executed
+// instruction.
+func WithTracer(t Tracer) Option {
+	return func(g *Machine) {
+		g.Tracer = t
+	}
+}
+
+// WithInstructionLimit sets the maximum number of instructions the machine
+// will execute.
+func WithInstructionLimit(limit Word) Option {
+	return func(g *Machine) {
+		g.InstructionLimit = limit
+	}
 }
 
-func New(out io.Writer) *Machine {
-	return &Machine{
+func New(opts ...Option) *Machine {
+	g := &Machine{
 		P:         Word(0),
 		S:         Word(0),
 		A:         Word(0),
 		X:         Word(0),
 		Y:         Word(0),
-		E:         Word(0),
-		Out:       out,
+		E:         ExceptionOK,
+		F:         Word(0),
+		Out:       io.Discard,
 		MemOffset: StackSize,
-		Memory:    make([]Word, MemSize),
 	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.Memory == nil {
+		g.Memory = make([]Word, MemSize)
+	}
+	return g
 }
 
 func (g *Machine) Next() Word {
+	if !g.validAddress(g.P) {
+		g.raise(ExceptionOutOfMemory, g.P)
+		return Word(0)
+	}
 	word := g.Memory[g.MemOffset+g.P]
 	g.P++
 	return word
 }
 
-func (g *Machine) Run() {
-	for {
-		instruction := g.Next()
-		if g.MemOffset+g.P >= MemSize {
-			g.E = ExceptionOutOfMemory
-			return
-		}
+// cancelCheckInterval is the number of instructions RunContext executes
+// between checks for cancellation of its context.
+const cancelCheckInterval = 1024
 
-		switch instruction {
-		case OpHALT:
-			return
-		case OpNOOP:
-			continue
-		case OpOUTA:
-			binary.Write(g.Out, binary.BigEndian, g.A)
-		case OpINCA:
-			g.A++
-		case OpINCX:
-			g.X++
-		case OpINCY:
-			g.Y++
-		case OpDECA:
-			g.A--
-		case OpDECX:
-			g.X--
-		case OpDECY:
-			g.Y--
-		case OpADDA:
-			switch g.Next() {
-			case RegX:
-				g.A += g.X
-			case RegY:
-				g.A += g.Y
-			}
-		case OpMULA:
-			switch g.Next() {
-			case RegX:
-				g.A *= g.X
-			case RegY:
-				g.A *= g.Y
+func (g *Machine) Run() error {
+	return g.RunContext(context.Background())
+}
+
+// RunContext runs the machine until it halts, faults, stops at a breakpoint or
+// watchpoint, or ctx is done, in which case it returns ctx.Err(). The
+// machine's state is left intact, so it can be resumed by running it again.
+func (g *Machine) RunContext(ctx context.Context) error {
+	done := ctx.Done()
+	for i := 0; ; i++ {
+		if done != nil && i%cancelCheckInterval == 0 {
+			select {
+			case <-done:
+				return ctx.Err()
+			default:
 			}
-		case OpMVAX:
-			g.X = g.A
-		case OpMVIAX:
-			g.X = g.Memory[g.MemOffset+g.A]
-		case OpMVAY:
-			g.Y = g.A
-		case OpMVAV:
-			offset := g.Next()
-			g.Memory[g.MemOffset+offset] = g.A
-		case OpMVVA:
-			offset := g.Next()
-			g.A = g.Memory[g.MemOffset+offset]
-		case OpSETA:
-			g.A = g.Next()
-		case OpSETX:
-			g.X = g.Next()
-		case OpSETY:
-			g.Y = g.Next()
-		case OpPSHA:
-			g.Memory[g.S] = g.A
-			g.S++
-		case OpPOPA:
-			g.S--
-			g.A = g.Memory[g.S]
-		case OpJUMP:
-			g.P = g.Memory[g.MemOffset+g.P]
-		case OpJXNZ:
-			if g.X != 0 {
-				g.P = g.Memory[g.MemOffset+g.P]
-			} else {
-				g.P++
+		}
+		if len(g.breakpoints) > 0 && g.breakpoints[g.P] && !(i == 0 && g.resuming && g.P == g.resumeP) {
+			g.resumeP, g.resuming = g.P, true
+			return &Stop{Reason: ErrBreakpoint, P: g.P}
+		}
+		start := g.P
+		halted, err := g.Step()
+		if halted || err != nil {
+			return err
+		}
+		if len(g.watchpoints) > 0 {
+			if stop := g.checkWatchpoints(start); stop != nil {
+				return stop
 			}
-		default:
-			g.E = ExceptionIllegalInstruction
-			return
 		}
 	}
 }
 
-func (g *Machine) RunProgram(program []Word) {
-	// Load program into machine
+// Step executes a single instruction. It reports whether the instruction was
+// HALT, and returns an *Exception if the instruction faulted.
+func (g *Machine) Step() (halted bool, err error) {
+	g.E = ExceptionOK
+	g.resuming = false
+	start := g.P
+	if g.InstructionLimit > 0 && g.executed >= g.InstructionLimit {
+		g.raise(ExceptionBudgetExhausted, g.executed)
+		return false, g.exception(start, Word(0))
+	}
+	g.executed++
+
+	var before Registers
+	if g.Tracer != nil {
+		before = g.Registers()
+	}
+	instruction := g.Next()
+	if g.E != ExceptionOK {
+		return false, g.exception(start, instruction)
+	}
+	if g.Tracer != nil {
+		defer g.trace(start, before)
+	}
+	if !g.validAddress(g.P) {
+		g.raise(ExceptionOutOfMemory, g.P)
+		return false, g.exception(start, instruction)
+	}
+
+	switch instruction {
+	case OpHALT:
+		return true, nil
+	case OpNOOP:
+	case OpOUTA:
+		binary.Write(g.Out, binary.BigEndian, g.A)
+	case OpOUTC:
+		// A value too large to be a character would otherwise be
+		// truncated to one that is.
+		r := utf8.RuneError
+		if g.A <= unicode.MaxRune {
+			r = rune(g.A)
+		}
+		io.WriteString(g.Out, string(r))
+	case OpOUTN:
+		io.WriteString(g.Out, strconv.FormatUint(uint64(g.A), 10))
+	case OpINA:
+		g.A = g.readInput()
+	case OpINCA:
+		g.A++
+	case OpINCX:
+		g.X++
+	case OpINCY:
+		g.Y++
+	case OpDECA:
+		g.A--
+	case OpDECX:
+		g.X--
+	case OpDECY:
+		g.Y--
+	case OpADDA:
+		switch g.Next() {
+		case RegX:
+			g.A += g.X
+		case RegY:
+			g.A += g.Y
+		}
+	case OpMULA:
+		switch g.Next() {
+		case RegX:
+			g.A *= g.X
+		case RegY:
+			g.A *= g.Y
+		}
+	case OpMVAX:
+		g.X = g.A
+	case OpMVIAX:
+		value := g.load(g.A)
+		if g.E == ExceptionOK {
+			g.X = value
+		}
+	case OpMVAY:
+		g.Y = g.A
+	case OpMVAV:
+		g.store(g.Next(), g.A)
+	case OpMVVA:
+		value := g.load(g.Next())
+		if g.E == ExceptionOK {
+			g.A = value
+		}
+	case OpSETA:
+		g.A = g.Next()
+	case OpSETX:
+		g.X = g.Next()
+	case OpSETY:
+		g.Y = g.Next()
+	case OpPSHA:
+		g.push(g.A)
+	case OpPOPA:
+		value := g.pop()
+		if g.E == ExceptionOK {
+			g.A = value
+		}
+	case OpJUMP:
+		g.jump(g.Next())
+	case OpJXNZ:
+		g.jumpIf(g.X != 0)
+	case OpCALL:
+		target := g.Next()
+		g.push(g.P)
+		if g.E == ExceptionOK {
+			g.jump(target)
+		}
+	case OpRTRN:
+		address := g.pop()
+		if g.E == ExceptionOK {
+			g.jump(address)
+		}
+	case OpCMPA:
+		g.compare(g.register(g.Next()))
+	case OpCMPAI:
+		g.compare(g.Next())
+	case OpJZ, OpJEQ:
+		g.jumpIf(g.F&FlagZero != 0)
+	case OpJNZ:
+		g.jumpIf(g.F&FlagZero == 0)
+	case OpJLT:
+		// JLT and JGT compare unsigned values, so a negative value in two's
+		// complement counts as greater than any positive one. JSLT and JSGT
+		// compare signed values.
+		g.jumpIf(g.F&FlagCarry != 0)
+	case OpJGT:
+		g.jumpIf(g.F&(FlagCarry|FlagZero) == 0)
+	case OpJSLT:
+		g.jumpIf(g.signedLess())
+	case OpJSGT:
+		g.jumpIf(g.F&FlagZero == 0 && !g.signedLess())
+	case OpJANZ:
+		g.jumpIf(g.A != 0)
+	case OpJAZ:
+		g.jumpIf(g.A == 0)
+	case OpJYNZ:
+		g.jumpIf(g.Y != 0)
+	case OpSUBA:
+		g.A -= g.register(g.Next())
+	case OpSUBAI:
+		g.A -= g.Next()
+	case OpDIVA, OpDIVAI, OpMODA, OpMODAI:
+		divisor := g.Next()
+		if instruction == OpDIVA || instruction == OpMODA {
+			divisor = g.register(divisor)
+		}
+		if divisor == 0 {
+			g.raise(ExceptionDivideByZero, divisor)
+			break
+		}
+		if instruction == OpDIVA || instruction == OpDIVAI {
+			g.A /= divisor
+		} else {
+			g.A %= divisor
+		}
+	case OpANDA:
+		g.A &= g.register(g.Next())
+	case OpANDAI:
+		g.A &= g.Next()
+	case OpORA:
+		g.A |= g.register(g.Next())
+	case OpORAI:
+		g.A |= g.Next()
+	case OpXORA:
+		g.A ^= g.register(g.Next())
+	case OpXORAI:
+		g.A ^= g.Next()
+	case OpNOTA:
+		g.A = ^g.A
+	case OpSHLA:
+		g.A <<= g.register(g.Next())
+	case OpSHLAI:
+		g.A <<= g.Next()
+	case OpSHRA:
+		g.A >>= g.register(g.Next())
+	case OpSHRAI:
+		g.A >>= g.Next()
+	case OpROLA:
+		g.A = g.rotate(g.register(g.Next()))
+	case OpROLAI:
+		g.A = g.rotate(g.Next())
+	case OpRORA:
+		g.A = g.rotate(-g.register(g.Next()))
+	case OpRORAI:
+		g.A = g.rotate(-g.Next())
+	default:
+		g.raise(ExceptionIllegalInstruction, instruction)
+	}
+	if g.E != ExceptionOK {
+		return false, g.exception(start, instruction)
+	}
+	return false, nil
+}
+
+// raise records an exception of the given kind, along with the operand that
+// caused it, stopping the machine after the current instruction.
+func (g *Machine) raise(kind ExceptionKind, operand Word) {
+	g.E = kind
+	g.operand = operand
+}
+
+// exception returns the *Exception describing the fault recorded in E.
+func (g *Machine) exception(p, opcode Word) *Exception {
+	return &Exception{
+		Kind:      g.E,
+		P:         p,
+		Opcode:    opcode,
+		Operand:   g.operand,
+		Registers: g.Registers(),
+	}
+}
+
+// Registers returns a snapshot of the machine's registers.
+func (g *Machine) Registers() Registers {
+	return Registers{P: g.P, S: g.S, A: g.A, X: g.X, Y: g.Y, F: g.F}
+}
+
+// readInput reads the next UTF-8 encoded character from In, the same
+// encoding OUTC writes, returning EOF when there is no more input or it
+// cannot be read. Input that isn't valid UTF-8 reads as utf8.RuneError. In is
+// read a byte at a time, so that no input past the character is consumed.
+func (g *Machine) readInput() Word {
+	if g.In == nil {
+		return EOF
+	}
+	var b [utf8.UTFMax]byte
+	if _, err := io.ReadFull(g.In, b[:1]); err != nil {
+		return EOF
+	}
+	var size int
+	switch {
+	case b[0] < utf8.RuneSelf:
+		return Word(b[0])
+	case b[0]&0xe0 == 0xc0:
+		size = 2
+	case b[0]&0xf0 == 0xe0:
+		size = 3
+	case b[0]&0xf8 == 0xf0:
+		size = 4
+	default:
+		return utf8.RuneError
+	}
+	if _, err := io.ReadFull(g.In, b[1:size]); err != nil {
+		return utf8.RuneError
+	}
+	r, _ := utf8.DecodeRune(b[:size])
+	return Word(r)
+}
+
+// register returns the value of the register identified by r.
+func (g *Machine) register(r Word) Word {
+	switch r {
+	case RegX:
+		return g.X
+	case RegY:
+		return g.Y
+	default:
+		return g.A
+	}
+}
+
+// rotate returns the A register rotated left by n bits. Rotating by the two's
+// complement of n rotates right instead.
+func (g *Machine) rotate(n Word) Word {
+	return Word(bits.RotateLeft64(uint64(g.A), int(n%64)))
+}
+
+// compare sets the flags register from the result of subtracting value from
+// the A register. The carry flag is set when value is greater than A, treating
+// both as unsigned.
+func (g *Machine) compare(value Word) {
+	result := g.A - value
+	g.F = 0
+	if result == 0 {
+		g.F |= FlagZero
+	}
+	if g.A < value {
+		g.F |= FlagCarry
+	}
+	if result>>63 == 1 {
+		g.F |= FlagNegative
+	}
+	// The subtraction overflows when A and value have different signs, and
+	// the result's sign differs from A's.
+	if (g.A^value)&(g.A^result)>>63 == 1 {
+		g.F |= FlagOverflow
+	}
+}
+
+// signedLess reports whether the last comparison found A less than the value
+// it was compared with, treating both as signed.
+func (g *Machine) signedLess() bool {
+	return (g.F&FlagNegative != 0) != (g.F&FlagOverflow != 0)
+}
+
+// jumpIf sets P to the address operand when cond is true, and otherwise skips
+// over the operand.
+func (g *Machine) jumpIf(cond bool) {
+	target := g.Next()
+	if cond {
+		g.jump(target)
+	}
+}
+
+// jump sets P to target, raising ExceptionInvalidAddress if it lies outside
+// memory.
+func (g *Machine) jump(target Word) {
+	if !g.validAddress(target) {
+		g.raise(ExceptionInvalidAddress, target)
+		return
+	}
+	g.P = target
+}
+
+// validAddress reports whether addr, which is relative to MemOffset, refers
+// to a word in memory.
+func (g *Machine) validAddress(addr Word) bool {
+	size := Word(len(g.Memory))
+	return g.MemOffset <= size && addr < size-g.MemOffset
+}
+
+// load returns the word at addr, raising ExceptionInvalidAddress if it lies
+// outside memory.
+func (g *Machine) load(addr Word) Word {
+	if !g.validAddress(addr) {
+		g.raise(ExceptionInvalidAddress, addr)
+		return Word(0)
+	}
+	return g.Memory[g.MemOffset+addr]
+}
+
+// store writes value to addr, raising ExceptionInvalidAddress if it lies
+// outside memory.
+func (g *Machine) store(addr, value Word) {
+	if !g.validAddress(addr) {
+		g.raise(ExceptionInvalidAddress, addr)
+		return
+	}
+	g.Memory[g.MemOffset+addr] = value
+}
+
+// push writes value to the top of the stack, raising ExceptionStackOverflow
+// if the stack is full.
+func (g *Machine) push(value Word) {
+	if g.S >= g.MemOffset || g.S >= Word(len(g.Memory)) {
+		g.raise(ExceptionStackOverflow, g.S)
+		return
+	}
+	g.Memory[g.S] = value
+	g.S++
+}
+
+// pop removes and returns the value at the top of the stack, raising
+// ExceptionStackUnderflow if the stack is empty.
+func (g *Machine) pop() Word {
+	if g.S == 0 {
+		g.raise(ExceptionStackUnderflow, g.S)
+		return Word(0)
+	}
+	if g.S > g.MemOffset || g.S > Word(len(g.Memory)) {
+		g.raise(ExceptionStackOverflow, g.S)
+		return Word(0)
+	}
+	g.S--
+	return g.Memory[g.S]
+}
+
+// Load copies program into memory at MemOffset without running it.
+func (g *Machine) Load(program []Word) error {
+	if g.MemOffset > Word(len(g.Memory)) || Word(len(program)) > Word(len(g.Memory))-g.MemOffset {
+		g.raise(ExceptionOutOfMemory, Word(len(program)))
+		return g.exception(g.P, Word(0))
+	}
 	copy(g.Memory[g.MemOffset:], program)
-	g.Run()
+	return nil
+}
+
+func (g *Machine) RunProgram(program []Word) error {
+	err := g.Load(program)
+	if err != nil {
+		return err
+	}
+	return g.Run()
 }
 
+// ref is an operand whose value is filled in once the whole program has
+// been read, since it may refer to labels defined further on.
 type ref struct {
-	Name    string
-	Line    int
-	Address Word
-	Value   Word
+	Expr      ast.Expression
+	Address   Word       // address of the word that holds the value
+	Here      Word       // address of the statement, which is the value of $
+	Expansion *expansion // the macro call the statement came from, if any
 }
 
 type symbolTable struct {
 	labels    map[string]Word
-	consts    map[string]Word
+	consts    map[string]*constant
 	variables map[string]Word
+	// lengths holds the number of characters in each variable, for len.
+	lengths map[string]Word
+	// defined holds where each label, constant and variable is defined.
+	defined map[string]token.Position
 }
 
 func newSymbolTable() *symbolTable {
 	return &symbolTable{
 		labels:    make(map[string]Word),
-		consts:    make(map[string]Word),
+		consts:    make(map[string]*constant),
 		variables: make(map[string]Word),
+		lengths:   make(map[string]Word),
+		defined:   make(map[string]token.Position),
 	}
 }
 
+// define records that name is defined at pos, returning an error if it
+// has already been defined as a label, constant or variable. A name missing
+// after a syntax error is ignored.
+func (t *symbolTable) define(name string, pos token.Position) error {
+	if name == "" {
+		return nil
+	}
+	if first, ok := t.defined[name]; ok {
+		return &lexer.Error{Pos: pos, Err: fmt.Errorf("%w: %s, first defined at %s", ErrSymbolRedefined, name, first)}
+	}
+	t.defined[name] = pos
+	return nil
+}
+
 func (t *symbolTable) defineLabel(name string, address Word) {
 	t.labels[name] = address
 }
 
-func (t *symbolTable) defineConst(name string, value Word) {
-	t.consts[name] = value
+func (t *symbolTable) defineConst(name string, value ast.Expression, here Word, x *expansion) {
+	t.consts[name] = &constant{value: value, here: here, expansion: x}
 }
 
-func (t *symbolTable) defineVariable(name string, value Word) {
-	t.variables[name] = value
+func (t *symbolTable) defineVariable(name string, address, length Word) {
+	t.variables[name] = address
+	t.lengths[name] = length
 }
 
-func (t *symbolTable) lookup(name string) (Word, bool) {
-	if value, ok := t.labels[name]; ok {
-		return value, ok
-	}
-	if value, ok := t.consts[name]; ok {
-		return value, ok
+// DebugInfo maps an assembled program back to the source it came from.
+type DebugInfo struct {
+	// Symbols holds the address of each label and variable.
+	Symbols map[string]Word
+	// Lines holds the source line of each instruction and variable, keyed
+	// by address. Those from included files are left out.
+	Lines map[Word]int
+	// CodeSize is the address just past the last instruction. Any words
+	// after it belong to variables declared at the end of the program.
+	CodeSize Word
+	// Entry is the address at which execution begins, the address of the
+	// EntryLabel label, if HasEntry reports that the program defines one.
+	Entry    Word
+	HasEntry bool
+}
+
+// EntryLabel is the name of the label marking a program's entry point, so
+// that subroutines and data can come before the main code:
+//
+//	.start
+//	CALL double
+const EntryLabel = "start"
+
+// AssembleOption configures how a program is assembled.
+type AssembleOption func(*assembleConfig)
+
+type assembleConfig struct {
+	filename   string
+	searchPath []string
+}
+
+// WithFilename sets the name of the file the program is read from, which
+// is included in the position of each error.
+func WithFilename(name string) AssembleOption {
+	return func(c *assembleConfig) {
+		c.filename = name
 	}
-	if value, ok := t.variables[name]; ok {
-		return value, ok
+}
+
+// WithSearchPath adds dirs to the directories searched for files named by
+// INCLUDE statements, after the directory of the file that includes them.
+func WithSearchPath(dirs ...string) AssembleOption {
+	return func(c *assembleConfig) {
+		c.searchPath = append(c.searchPath, dirs...)
 	}
-	return Word(0), false
 }
 
-func Assemble(reader io.Reader) ([]Word, error) {
+func Assemble(reader io.Reader, opts ...AssembleOption) ([]Word, error) {
+	program, _, err := AssembleWithDebugInfo(reader, opts...)
+	return program, err
+}
+
+// AssembleWithDebugInfo assembles the program read from reader, like
+// Assemble, and also returns its symbols and source line mapping.
+func AssembleWithDebugInfo(reader io.Reader, opts ...AssembleOption) ([]Word, *DebugInfo, error) {
+	config := &assembleConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
 	program := []Word{}
 	refs := []ref{}
 	symbols := newSymbolTable()
+	debug := &DebugInfo{
+		Symbols: make(map[string]Word),
+		Lines:   make(map[Word]int),
+	}
 
-	l, err := lexer.New(reader)
+	l, err := lexer.NewFile(config.filename, reader)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	p := parser.New(l)
 	astProgram := p.ParseProgram()
 	if astProgram == nil {
-		return nil, errors.New("failed to parse program")
+		return nil, nil, errors.New("failed to parse program")
 	}
-	if len(p.Errors()) > 0 {
-		return nil, p.Errors()[0]
+	errs := p.Errors()
+	stmts, includeErrs := includeFiles(astProgram.Statements, config.filename, config.searchPath)
+	errs = append(errs, includeErrs...)
+	// Statements on lines with syntax errors are incomplete, so they are
+	// not assembled, but the names they define are still recorded so that
+	// references to them aren't reported as well.
+	invalid := make(map[sourceLine]bool)
+	for _, err := range errs {
+		invalid[sourceLine{err.Pos.Filename, err.Pos.Line}] = true
 	}
+	statements, macroErrs := expandMacros(stmts, invalid)
+	errs = append(errs, macroErrs...)
 
 	// Assemble program
-	for _, stmt := range astProgram.Statements {
-		switch stmt := stmt.(type) {
+	for _, s := range statements {
+		// Operands from a macro's body are resolved along with the rest,
+		// and errors in them point to the macro call.
+		firstRef := len(refs)
+		var e *lexer.Error
+		switch stmt := s.Statement.(type) {
 		case ast.ConstantDefinitionStatement:
-			value := stmt.Value.(ast.IntegerLiteral).Value
-			symbols.defineConst(stmt.Name.Value, Word(value))
+			if errors.As(s.expansion.wrap(symbols.define(stmt.Name.Value, stmt.Name.Pos())), &e) {
+				errs = append(errs, e)
+				continue
+			}
+			symbols.defineConst(stmt.Name.Value, stmt.Value, Word(len(program)), s.expansion)
 		case ast.LabelDefinitionStatement:
 			name := strings.TrimPrefix(stmt.TokenLiteral(), ".")
+			if errors.As(s.expansion.wrap(symbols.define(name, stmt.Pos())), &e) {
+				errs = append(errs, e)
+				continue
+			}
 			symbols.defineLabel(name, Word(len(program)))
 		case ast.VariableDefinitionStatement:
-			symbols.defineVariable(stmt.Name.Value, Word(len(program)))
+			if errors.As(s.expansion.wrap(symbols.define(stmt.Name.Value, stmt.Name.Pos())), &e) {
+				errs = append(errs, e)
+				continue
+			}
+			address := Word(len(program))
+			if stmt.Token.Filename == config.filename {
+				debug.Lines[address] = stmt.Token.Line
+			}
 			switch operand := stmt.Value.(type) {
-			case ast.IntegerLiteral:
-				program = append(program, Word(operand.Value))
+			case nil:
+				// The definition is incomplete, and its syntax error
+				// has already been reported.
+				symbols.defineVariable(stmt.Name.Value, address, 0)
 			case ast.StringLiteral:
-				strSlice := make([]Word, len(operand.Value)+1)
-				for i, c := range operand.Value {
+				chars := []rune(operand.Value)
+				strSlice := make([]Word, len(chars)+1)
+				for i, c := range chars {
 					strSlice[i] = Word(c)
 				}
 				program = append(program, strSlice...)
+				symbols.defineVariable(stmt.Name.Value, address, Word(len(chars)))
 			default:
-				return nil, errors.New("invalid variable definition")
+				program, refs = appendValue(program, refs, operand, address)
+				symbols.defineVariable(stmt.Name.Value, address, 1)
 			}
 		case ast.InstructionStatement:
-			program, refs, err = assembleInstructionStatement(stmt, program, refs)
-			if err != nil {
-				return nil, err
+			if invalid[sourceLine{stmt.Token.Filename, stmt.Token.Line}] {
+				continue
 			}
+			if stmt.Token.Filename == config.filename {
+				debug.Lines[Word(len(program))] = stmt.Token.Line
+			}
+			next, nextRefs, err := assembleInstructionStatement(stmt, program, refs)
+			if errors.As(s.expansion.wrap(err), &e) {
+				errs = append(errs, e)
+				continue
+			}
+			program, refs = next, nextRefs
+			debug.CodeSize = Word(len(program))
 		default:
-			return nil, fmt.Errorf("unknown statement type: %T", stmt)
+			return nil, nil, fmt.Errorf("unknown statement type: %T", stmt)
+		}
+		for i := firstRef; i < len(refs); i++ {
+			refs[i].Expansion = s.expansion
 		}
 	}
 
 	// Resolve references to labels and consts
+	errs = append(errs, symbols.constantErrors()...)
 	for _, r := range refs {
-		value, ok := symbols.lookup(r.Name)
-		if !ok {
-			return nil, fmt.Errorf("%w: %s at line %d", ErrUnknownIdentifier, r.Name, r.Line)
+		value, err := symbols.evaluate(r.Expr, r.Here)
+		var e *lexer.Error
+		if errors.As(r.Expansion.wrap(err), &e) {
+			errs = append(errs, e)
+			continue
 		}
 		program[r.Address] = value
 	}
+	// Execution can only start at an instruction, and an executable's
+	// entry point must be in its code segment.
+	if entry, ok := symbols.labels[EntryLabel]; ok && entry >= debug.CodeSize {
+		errs.Add(symbols.defined[EntryLabel], fmt.Errorf("%w: .%s must label an instruction", ErrInvalidEntryPoint, EntryLabel))
+	}
+	if len(errs) > 0 {
+		errs.Sort()
+		return nil, nil, errs
+	}
 
-	return program, nil
+	for name, address := range symbols.labels {
+		debug.Symbols[name] = address
+	}
+	debug.Entry, debug.HasEntry = symbols.labels[EntryLabel]
+	for name, address := range symbols.variables {
+		debug.Symbols[name] = address
+	}
+
+	return program, debug, nil
+}
+
+// errorAt returns err, followed by detail, at the position of node.
+func errorAt(node ast.Node, err error, detail string) error {
+	return &lexer.Error{Pos: node.Pos(), Err: fmt.Errorf("%w: %s", err, detail)}
 }
 
 func assembleInstructionStatement(stmt ast.InstructionStatement, program []Word, refs []ref) ([]Word, []ref, error) {
 	if stmt.Operand1 == nil {
 		opcode, ok := opcodes[stmt.TokenLiteral()]
 		if !ok {
-			return nil, nil, fmt.Errorf("%w: %s at line %d", ErrUndefinedInstruction, stmt.TokenLiteral(), stmt.Token.Line)
+			return nil, nil, errorAt(stmt, ErrUndefinedInstruction, stmt.TokenLiteral())
+		}
+		if operandKinds[opcode] != OperandNone {
+			return nil, nil, errorAt(stmt, ErrInvalidOperand, stmt.TokenLiteral()+" needs an operand")
 		}
 		program = append(program, opcode)
 		return program, refs, nil
 	}
 
 	instruction := stmt.TokenLiteral()
+	here := Word(len(program))
 
 	switch instruction {
 	case "MOVE":
@@ -349,124 +1083,136 @@ func assembleInstructionStatement(stmt ast.InstructionStatement, program []Word,
 				opcodeStr += operand2.TokenLiteral()
 				opcode, ok := opcodes[opcodeStr]
 				if !ok {
-					return nil, nil, fmt.Errorf("%w: %s at line %d", ErrUnknownOpcode, opcodeStr, stmt.Token.Line)
+					return nil, nil, errorAt(stmt, ErrUnknownOpcode, opcodeStr)
 				}
 				program = append(program, opcode)
-			case ast.Identifier:
+			case ast.StringLiteral:
+				return nil, nil, errorAt(stmt.Operand2, ErrInvalidOperand, stmt.TokenLiteral())
+			default:
 				opcodeStr := fmt.Sprintf("%s%s%s", "MV", operand1.TokenLiteral(), "V")
 				opcode, ok := opcodes[opcodeStr]
 				if !ok {
-					return nil, nil, fmt.Errorf("%w: %s at line %d", ErrUnknownOpcode, opcodeStr, stmt.Token.Line)
+					return nil, nil, errorAt(stmt, ErrUnknownOpcode, opcodeStr)
 				}
-				program = append(program, opcode)
-				r := ref{
-					Name:    operand2.TokenLiteral(),
-					Line:    operand2.Token.Line,
-					Address: Word(len(program)),
-				}
-				refs = append(refs, r)
-				program = append(program, Word(0))
+				program, refs = appendValue(append(program, opcode), refs, operand2, here)
 			}
-		case ast.Identifier:
+		case ast.StringLiteral:
+			return nil, nil, errorAt(stmt.Operand1, ErrInvalidOperand, stmt.TokenLiteral())
+		default:
 			opcodeStr += "V"
-			switch operand2 := stmt.Operand2.(type) {
-			case ast.RegisterLiteral:
-				opcodeStr += operand2.TokenLiteral()
-				opcode, ok := opcodes[opcodeStr]
-				if !ok {
-					return nil, nil, fmt.Errorf("%w: %s at line %d", ErrUnknownOpcode, opcodeStr, stmt.Token.Line)
-				}
-				program = append(program, opcode)
-				r := ref{
-					Name:    operand1.TokenLiteral(),
-					Line:    operand1.Token.Line,
-					Address: Word(len(program)),
-				}
-				refs = append(refs, r)
-				program = append(program, Word(0))
+			operand2, ok := stmt.Operand2.(ast.RegisterLiteral)
+			if !ok {
+				return nil, nil, errorAt(stmt.Operand2, ErrInvalidOperand, stmt.TokenLiteral())
 			}
-		default:
-			return nil, nil, fmt.Errorf("%w: %s at line %d", ErrInvalidOperand, operand1.TokenLiteral(), stmt.Token.Line)
+			opcodeStr += operand2.TokenLiteral()
+			opcode, ok := opcodes[opcodeStr]
+			if !ok {
+				return nil, nil, errorAt(stmt, ErrUnknownOpcode, opcodeStr)
+			}
+			program, refs = appendValue(append(program, opcode), refs, operand1, here)
 		}
-	case "MULA", "ADDA":
+	case "MULA", "ADDA", "CMPA", "SUBA", "DIVA", "MODA",
+		"ANDA", "ORA", "XORA", "SHLA", "SHRA", "ROLA", "RORA":
 		opcode, ok := opcodes[instruction]
 		if !ok {
-			return nil, nil, fmt.Errorf("%w: %s at line %d", ErrUndefinedInstruction, stmt.TokenLiteral(), stmt.Token.Line)
+			return nil, nil, errorAt(stmt, ErrUndefinedInstruction, stmt.TokenLiteral())
 		}
+		// Instructions with an immediate form are encoded with a separate
+		// opcode, suffixed with "I", followed by the value itself.
+		immediateOpcode, hasImmediate := opcodes[instruction+"I"]
 		switch operand := stmt.Operand1.(type) {
 		case ast.RegisterLiteral:
 			register, ok := registers[operand.TokenLiteral()]
 			if !ok {
-				return nil, nil, fmt.Errorf("%w: %s at line %d", ErrInvalidRegister, operand.TokenLiteral(), operand.Token.Line)
+				return nil, nil, errorAt(operand, ErrInvalidRegister, operand.TokenLiteral())
 			}
 			program = append(program, opcode, register)
+		case ast.StringLiteral:
+			return nil, nil, errorAt(stmt.Operand1, ErrInvalidOperand, stmt.TokenLiteral())
 		default:
-			return nil, nil, fmt.Errorf("%w: %s at line %d", ErrInvalidOperand, stmt.TokenLiteral(), stmt.Token.Line)
+			if !hasImmediate {
+				return nil, nil, errorAt(stmt.Operand1, ErrInvalidOperand, stmt.TokenLiteral())
+			}
+			program, refs = appendValue(append(program, immediateOpcode), refs, operand, here)
 		}
 	case "SETA", "SETX", "SETY":
 		opcode, ok := opcodes[instruction]
 		if !ok {
-			return nil, nil, fmt.Errorf("%w: %s at line %d", ErrUndefinedInstruction, stmt.TokenLiteral(), stmt.Token.Line)
+			return nil, nil, errorAt(stmt, ErrUndefinedInstruction, stmt.TokenLiteral())
 		}
 		switch operand := stmt.Operand1.(type) {
-		case ast.IntegerLiteral:
-			program = append(program, opcode, Word(operand.Value))
-		case ast.CharacterLiteral:
-			program = append(program, opcode, Word(operand.Value))
-		case ast.Identifier:
-			program = append(program, opcode)
-			r := ref{
-				Name:    operand.TokenLiteral(),
-				Line:    operand.Token.Line,
-				Address: Word(len(program)),
-			}
-			refs = append(refs, r)
-			program = append(program, Word(0))
+		case ast.RegisterLiteral, ast.StringLiteral:
+			return nil, nil, errorAt(stmt.Operand1, ErrInvalidOperand, stmt.TokenLiteral())
 		default:
-			return nil, nil, fmt.Errorf("%w: %s at line %d", ErrInvalidOperand, stmt.TokenLiteral(), stmt.Token.Line)
+			program, refs = appendValue(append(program, opcode), refs, operand, here)
 		}
-	case "JUMP", "JXNZ":
+	case "JUMP", "JXNZ", "CALL", "JZ", "JNZ", "JEQ", "JLT", "JGT", "JSLT", "JSGT", "JANZ", "JAZ", "JYNZ":
 		opcode, ok := opcodes[instruction]
 		if !ok {
-			return nil, nil, fmt.Errorf("%w: %s at line %d", ErrUndefinedInstruction, stmt.TokenLiteral(), stmt.Token.Line)
+			return nil, nil, errorAt(stmt, ErrUndefinedInstruction, stmt.TokenLiteral())
 		}
 		switch operand := stmt.Operand1.(type) {
-		case ast.IntegerLiteral:
-			program = append(program, opcode, Word(operand.Value))
-		case ast.Identifier:
-			program = append(program, opcode)
-			r := ref{
-				Name:    operand.TokenLiteral(),
-				Line:    operand.Token.Line,
-				Address: Word(len(program)),
-			}
-			refs = append(refs, r)
-			program = append(program, Word(0))
+		case ast.RegisterLiteral, ast.StringLiteral, ast.CharacterLiteral:
+			return nil, nil, errorAt(stmt.Operand1, ErrInvalidOperand, stmt.TokenLiteral())
 		default:
-			return nil, nil, fmt.Errorf("%w: %s at line %d", ErrInvalidOperand, stmt.TokenLiteral(), stmt.Token.Line)
+			program, refs = appendValue(append(program, opcode), refs, operand, here)
 		}
+	default:
+		return nil, nil, errorAt(stmt.Operand1, ErrInvalidOperand, instruction+" takes no operand")
 	}
 
 	return program, refs, nil
 }
 
-func (g *Machine) AssembleAndRun(r io.Reader) error {
-	program, err := Assemble(r)
+// appendValue appends a word to hold the value of expr, an operand of the
+// statement at address here. The value is filled in once all the program's
+// symbols are known.
+func appendValue(program []Word, refs []ref, expr ast.Expression, here Word) ([]Word, []ref) {
+	refs = append(refs, ref{Expr: expr, Address: Word(len(program)), Here: here})
+	return append(program, 0), refs
+}
+
+// AssembleExecutable assembles the program read from reader into an
+// executable, with any variables declared after the last instruction in its
+// data segment.
+func AssembleExecutable(reader io.Reader, opts ...AssembleOption) (*Executable, error) {
+	program, info, err := AssembleWithDebugInfo(reader, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Executable{
+		Entry:    info.Entry,
+		HasEntry: info.HasEntry,
+		Code:     program[:info.CodeSize],
+		Data:     program[info.CodeSize:],
+	}, nil
+}
+
+// AssembleAndRun assembles the program read from r and runs it from its
+// entry point.
+func (g *Machine) AssembleAndRun(r io.Reader, opts ...AssembleOption) error {
+	exe, err := AssembleExecutable(r, opts...)
 	if err != nil {
 		return err
 	}
-	g.RunProgram(program)
-	return nil
+	return g.RunExecutable(exe)
+}
+
+func RunFile(path string, opts ...Option) int {
+	return runFile(path, nil, opts...)
 }
 
-func RunFile(path string) int {
+func runFile(path string, asmOpts []AssembleOption, opts ...Option) int {
 	content, err := os.Open(path)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return 1
 	}
-	g := New(os.Stdout)
-	err = g.AssembleAndRun(content)
+	defer content.Close()
+	opts = append([]Option{WithInput(os.Stdin), WithOutput(os.Stdout)}, opts...)
+	g := New(opts...)
+	asmOpts = append([]AssembleOption{WithFilename(path)}, asmOpts...)
+	err = g.AssembleAndRun(content, asmOpts...)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return 1
@@ -474,13 +1220,15 @@ func RunFile(path string) int {
 	return 0
 }
 
-func Compile(in io.Reader, out io.Writer) error {
-	program, err := Assemble(in)
+// Compile assembles the program read from in and writes it to out in the
+// executable format.
+func Compile(in io.Reader, out io.Writer, opts ...AssembleOption) error {
+	exe, err := AssembleExecutable(in, opts...)
 	if err != nil {
 		return err
 	}
 
-	err = binary.Write(out, binary.BigEndian, program)
+	_, err = exe.WriteTo(out)
 	if err != nil {
 		return err
 	}
@@ -488,8 +1236,64 @@ func Compile(in io.Reader, out io.Writer) error {
 	return nil
 }
 
+// ReadProgram reads a legacy raw image, a program of big-endian words with no
+// header, from r.
+func ReadProgram(r io.Reader) ([]Word, error) {
+	input, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(input)%8 != 0 {
+		return nil, fmt.Errorf("%w: raw image of %d bytes is not a whole number of words", ErrInvalidExecutable, len(input))
+	}
+	program := make([]Word, len(input)/8)
+	err = binary.Read(bytes.NewReader(input), binary.BigEndian, &program)
+	if err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+// ReadFile reads a program from r, either in the executable format or, if
+// raw is set, as a legacy raw image, which is treated as an executable whose
+// code segment is the whole image.
+func ReadFile(r io.Reader, raw bool) (*Executable, error) {
+	if !raw {
+		return ReadExecutable(r)
+	}
+	program, err := ReadProgram(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Executable{Code: program}, nil
+}
+
+// searchPathFlag is a flag that can be given more than once, each time
+// adding a directory to the search path for included files.
+type searchPathFlag []string
+
+func (f *searchPathFlag) String() string {
+	return strings.Join(*f, string(os.PathListSeparator))
+}
+
+func (f *searchPathFlag) Set(dir string) error {
+	*f = append(*f, dir)
+	return nil
+}
+
 func MainCompile() int {
-	fileName := os.Args[1]
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	var searchPath searchPathFlag
+	fs.Var(&searchPath, "I", "also look for included files in `DIR` (may be repeated)")
+	err := fs.Parse(os.Args[1:])
+	if err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gc [-I DIR]... FILE")
+		return 1
+	}
+	fileName := fs.Arg(0)
 	outputFile := strings.TrimSuffix(fileName, ".g")
 
 	in, err := os.Open(fileName)
@@ -499,14 +1303,16 @@ func MainCompile() int {
 	}
 	defer in.Close()
 
-	out, err := os.Create(outputFile)
+	// Compile into memory first, so that no output file is left behind if
+	// the program has errors.
+	var out bytes.Buffer
+	err = Compile(in, &out, WithFilename(fileName), WithSearchPath(searchPath...))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return 1
 	}
-	defer out.Close()
 
-	err = Compile(in, out)
+	err = os.WriteFile(outputFile, out.Bytes(), 0o644)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return 1
@@ -515,31 +1321,61 @@ func MainCompile() int {
 	return 0
 }
 
+func MainAssembleAndRun() int {
+	fs := flag.NewFlagSet("gmachine", flag.ContinueOnError)
+	trace := fs.Bool("trace", false, "write a trace of each executed instruction to stderr")
+	var searchPath searchPathFlag
+	fs.Var(&searchPath, "I", "also look for included files in `DIR` (may be repeated)")
+	err := fs.Parse(os.Args[1:])
+	if err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gmachine [--trace] [-I DIR]... FILE")
+		return 1
+	}
+
+	opts := []Option{}
+	if *trace {
+		opts = append(opts, WithTracer(NewTextTracer(os.Stderr)))
+	}
+	return runFile(fs.Arg(0), []AssembleOption{WithSearchPath(searchPath...)}, opts...)
+}
+
 func MainRun() int {
-	f, err := os.Open(os.Args[1])
+	fs := flag.NewFlagSet("gr", flag.ContinueOnError)
+	trace := fs.Bool("trace", false, "write a trace of each executed instruction to stderr")
+	raw := fs.Bool("raw", false, "load FILE as a legacy raw image with no header")
+	err := fs.Parse(os.Args[1:])
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
 		return 1
 	}
-	defer f.Close()
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gr [--trace] [--raw] FILE")
+		return 1
+	}
 
-	input, err := io.ReadAll(f)
+	f, err := os.Open(fs.Arg(0))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return 1
 	}
+	defer f.Close()
 
-	program := make([]Word, len(input)/8)
-	err = binary.Read(bytes.NewReader(input), binary.BigEndian, &program)
+	exe, err := ReadFile(f, *raw)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return 1
 	}
 
-	g := New(os.Stdout)
-	g.RunProgram(program)
-	if g.E != 0 {
-		fmt.Fprintf(os.Stderr, "exception number: %d\n", g.E)
+	opts := []Option{WithInput(os.Stdin), WithOutput(os.Stdout)}
+	if *trace {
+		opts = append(opts, WithTracer(NewTextTracer(os.Stderr)))
+	}
+	g := New(opts...)
+	err = g.RunExecutable(exe)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		return 1
 	}
 
@@ -2,16 +2,23 @@ package gmachine_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"gmachine/lexer"
 	"gmachine/parser"
+	"gmachine/token"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"gmachine"
+	"gmachine/disasm"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/rogpeppe/go-internal/testscript"
@@ -19,8 +26,11 @@ import (
 
 func TestMain(m *testing.M) {
 	os.Exit(testscript.RunMain(m, map[string]func() int{
-		"gc": gmachine.MainCompile,
-		"gr": gmachine.MainRun,
+		"gc":       gmachine.MainCompile,
+		"gr":       gmachine.MainRun,
+		"gmachine": gmachine.MainAssembleAndRun,
+		"gdbg":     gmachine.MainDebug,
+		"gdis":     disasm.Main,
 	}))
 }
 
@@ -32,7 +42,7 @@ func Test(t *testing.T) {
 
 func TestNew(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	var wantP gmachine.Word = 0
 	if wantP != g.P {
 		t.Errorf("want initial P value %d, got %d", wantP, g.P)
@@ -53,6 +63,10 @@ func TestNew(t *testing.T) {
 	if wantY != g.Y {
 		t.Errorf("want initial Y value %d, got %d", wantY, g.Y)
 	}
+	var wantF gmachine.Word = 0
+	if wantF != g.F {
+		t.Errorf("want initial F value %d, got %d", wantF, g.F)
+	}
 	var wantMemValue gmachine.Word = 0
 	gotMemValue := g.Memory[gmachine.MemSize-1]
 	if wantMemValue != gotMemValue {
@@ -65,9 +79,51 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNew_AppliesMemoryAndStackSizeOptions(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New(
+		gmachine.WithMemorySize(64),
+		gmachine.WithStackSize(16),
+	)
+	wantMemSize := 64
+	if wantMemSize != len(g.Memory) {
+		t.Errorf("want memory size %d, got %d", wantMemSize, len(g.Memory))
+	}
+	var wantMemOffset gmachine.Word = 16
+	if wantMemOffset != g.MemOffset {
+		t.Errorf("want memory offset %d, got %d", wantMemOffset, g.MemOffset)
+	}
+}
+
+func TestNew_AppliesIOOptions(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	in := strings.NewReader("x")
+	g := gmachine.New(gmachine.WithInput(in), gmachine.WithOutput(&buf))
+	if g.In != in {
+		t.Errorf("want input %v, got %v", in, g.In)
+	}
+	if g.Out != &buf {
+		t.Errorf("want output %v, got %v", &buf, g.Out)
+	}
+}
+
+func TestNew_DiscardsOutputByDefault(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, "SETA 'a'\nOUTA\nOUTC\nOUTN\nHALT")
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var wantE = gmachine.ExceptionOK
+	if wantE != g.E {
+		t.Errorf("want error code value %d, got %d", wantE, g.E)
+	}
+}
+
 func TestHALT(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	var wantP gmachine.Word = 1
 	err := assembleAndRunFromString(g, "HALT")
 	if err != nil {
@@ -80,9 +136,9 @@ func TestHALT(t *testing.T) {
 
 func TestNOOP(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	var wantP gmachine.Word = 2
-	err := assembleAndRunFromString(g, "NOOP")
+	err := assembleAndRunFromString(g, "NOOP\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error", err)
 	}
@@ -93,8 +149,8 @@ func TestNOOP(t *testing.T) {
 
 func TestINCA(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
-	err := assembleAndRunFromString(g, "INCA")
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, "INCA\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error", err)
 	}
@@ -106,8 +162,8 @@ func TestINCA(t *testing.T) {
 
 func TestINCX(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
-	err := assembleAndRunFromString(g, "INCX")
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, "INCX\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
 	}
@@ -119,8 +175,8 @@ func TestINCX(t *testing.T) {
 
 func TestINCY(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
-	err := assembleAndRunFromString(g, "INCY")
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, "INCY\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
 	}
@@ -130,38 +186,372 @@ func TestINCY(t *testing.T) {
 	}
 }
 
+func TestStep_ExecutesOneInstructionAtATime(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	program, err := assembleFromString(`
+SETA 41
+INCA
+MOVE A -> X
+HALT
+`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	err = g.Load(program)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	want := []gmachine.Registers{
+		{P: 2, A: 41},
+		{P: 3, A: 42},
+		{P: 4, A: 42, X: 42},
+		{P: 5, A: 42, X: 42},
+	}
+	for i, wantRegs := range want {
+		halted, err := g.Step()
+		if err != nil {
+			t.Fatalf("step %d: didn't expect an error: %v", i, err)
+		}
+		wantHalted := i == len(want)-1
+		if wantHalted != halted {
+			t.Errorf("step %d: want halted %t, got %t", i, wantHalted, halted)
+		}
+		got := g.Registers()
+		if !cmp.Equal(wantRegs, got) {
+			t.Errorf("step %d: %s", i, cmp.Diff(wantRegs, got))
+		}
+	}
+}
+
+func TestStep_ReturnsExceptionForFaultingInstruction(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.Load([]gmachine.Word{gmachine.OpINCA, gmachine.OpPOPA})
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	halted, err := g.Step()
+	if halted || err != nil {
+		t.Fatalf("want first step to succeed, got halted=%t err=%v", halted, err)
+	}
+	halted, err = g.Step()
+	if halted {
+		t.Error("didn't expect the machine to halt")
+	}
+	var wantE = gmachine.ExceptionStackUnderflow
+	if !errors.Is(err, wantE) {
+		t.Errorf("want error %v, got %v", wantE, err)
+	}
+	var wantA gmachine.Word = 1
+	if wantA != g.A {
+		t.Errorf("want A %d, got %d", wantA, g.A)
+	}
+}
+
 func TestIllegalInstruction(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
-	g.RunProgram([]gmachine.Word{
+	g := gmachine.New()
+	err := g.RunProgram([]gmachine.Word{
 		0,
 	})
 	var wantE = gmachine.ExceptionIllegalInstruction
+	if !errors.Is(err, wantE) {
+		t.Errorf("want error %v, got %v", wantE, err)
+	}
 	if wantE != g.E {
 		t.Errorf("want error code value %d, got %d", wantE, g.E)
 	}
 }
 
+func TestException_RecordsFaultDetails(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.RunProgram([]gmachine.Word{
+		gmachine.OpSETA, 768,
+		gmachine.OpSETX, 7,
+		gmachine.OpMVIAX,
+		gmachine.OpHALT,
+	})
+	var exc *gmachine.Exception
+	if !errors.As(err, &exc) {
+		t.Fatalf("want *gmachine.Exception, got %T (%v)", err, err)
+	}
+	want := &gmachine.Exception{
+		Kind:    gmachine.ExceptionInvalidAddress,
+		P:       4,
+		Opcode:  gmachine.OpMVIAX,
+		Operand: 768,
+		Registers: gmachine.Registers{
+			P: 5,
+			A: 768,
+			X: 7,
+		},
+	}
+	if !cmp.Equal(want, exc) {
+		t.Error(cmp.Diff(want, exc))
+	}
+}
+
+func TestException_Error(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		program []gmachine.Word
+		want    string
+	}{
+		{
+			[]gmachine.Word{gmachine.OpNOOP, 0xff},
+			"illegal instruction 0xff at P=1",
+		},
+		{
+			[]gmachine.Word{gmachine.OpSETA, 1, gmachine.OpDIVAI, 0},
+			"divide by zero at P=2 (DIVAI)",
+		},
+		{
+			[]gmachine.Word{gmachine.OpPOPA},
+			"stack underflow at P=0 (POPA)",
+		},
+		{
+			[]gmachine.Word{gmachine.OpJUMP, 0x1000},
+			"invalid address 0x1000 at P=0 (JUMP)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			g := gmachine.New()
+			err := g.RunProgram(tt.program)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			got := err.Error()
+			if tt.want != got {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
 func TestOutOfMemoryException(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	g.P = gmachine.MemSize - gmachine.StackSize - 1
 	err := assembleAndRunFromString(g, "NOOP")
+	var wantE = gmachine.ExceptionOutOfMemory
+	if !errors.Is(err, wantE) {
+		t.Errorf("want error %v, got %v", wantE, err)
+	}
+	if wantE != g.E {
+		t.Errorf("want error code value %d, got %d", wantE, g.E)
+	}
+}
+
+func TestOutOfMemoryException_UsesConfiguredMemorySize(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New(
+		gmachine.WithMemorySize(8),
+		gmachine.WithStackSize(2),
+	)
+	err := assembleAndRunFromString(g, "NOOP\nNOOP\nNOOP\nNOOP\nNOOP\nNOOP")
+	var wantE = gmachine.ExceptionOutOfMemory
+	if !errors.Is(err, wantE) {
+		t.Errorf("want error %v, got %v", wantE, err)
+	}
+	if wantE != g.E {
+		t.Errorf("want error code value %d, got %d", wantE, g.E)
+	}
+}
+
+func TestBudgetExhaustedException(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New(gmachine.WithInstructionLimit(10))
+	err := assembleAndRunFromString(g, `
+.loop
+INCA
+JUMP loop
+`)
+	var wantE = gmachine.ExceptionBudgetExhausted
+	if !errors.Is(err, wantE) {
+		t.Errorf("want error %v, got %v", wantE, err)
+	}
+	if wantE != g.E {
+		t.Errorf("want error code value %d, got %d", wantE, g.E)
+	}
+	var wantA gmachine.Word = 5
+	if wantA != g.A {
+		t.Errorf("want A %d, got %d", wantA, g.A)
+	}
+}
+
+func TestRunContext_StopsInfiniteLoopWhenCancelled(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	program, err := assembleFromString(".loop\nINCA\nJUMP loop")
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	err = g.Load(program)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = g.RunContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want error %v, got %v", context.DeadlineExceeded, err)
+	}
+	if g.A == 0 {
+		t.Error("want the loop to have run before being stopped")
+	}
+}
+
+func TestRunContext_ReturnsImmediatelyForCancelledContext(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.Load([]gmachine.Word{gmachine.OpINCA, gmachine.OpHALT})
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = g.RunContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want error %v, got %v", context.Canceled, err)
+	}
+	var wantA gmachine.Word = 0
+	if wantA != g.A {
+		t.Errorf("want A %d, got %d", wantA, g.A)
+	}
+	err = g.Run()
+	if err != nil {
+		t.Fatal("didn't expect an error resuming the machine:", err)
+	}
+	wantA = 1
+	if wantA != g.A {
+		t.Errorf("want A %d, got %d", wantA, g.A)
+	}
+}
+
+func TestInstructionLimit_AllowsProgramsWithinBudget(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New(gmachine.WithInstructionLimit(3))
+	err := assembleAndRunFromString(g, "INCA\nINCA\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error", err)
 	}
+	var wantE = gmachine.ExceptionOK
+	if wantE != g.E {
+		t.Errorf("want error code value %d, got %d", wantE, g.E)
+	}
+}
+
+func TestMemoryAccessExceptions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		program string
+		wantE   gmachine.ExceptionKind
+	}{
+		{"POPA on empty stack", "POPA", gmachine.ExceptionStackUnderflow},
+		{"RTRN on empty stack", "RTRN", gmachine.ExceptionStackUnderflow},
+		{"PSHA on full stack", ".loop\nPSHA\nJUMP loop", gmachine.ExceptionStackOverflow},
+		{"CALL on full stack", ".loop\nCALL loop", gmachine.ExceptionStackOverflow},
+		{"JUMP outside memory", "JUMP 5000", gmachine.ExceptionInvalidAddress},
+		{"JXNZ outside memory", "SETX 1\nJXNZ 0xFFFFFFFFFFFFFFFF", gmachine.ExceptionInvalidAddress},
+		{"CALL outside memory", "CALL 768", gmachine.ExceptionInvalidAddress},
+		{"RTRN outside memory", "SETA 768\nPSHA\nRTRN", gmachine.ExceptionInvalidAddress},
+		{"MOVE *A outside memory", "SETA 768\nMOVE *A -> X", gmachine.ExceptionInvalidAddress},
+		{"MOVE *A wrapping address", "SETA 0xFFFFFFFFFFFFFFFF\nMOVE *A -> X", gmachine.ExceptionInvalidAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := gmachine.New()
+			err := assembleAndRunFromString(g, tt.program)
+			if !errors.Is(err, tt.wantE) {
+				t.Errorf("want error %v, got %v", tt.wantE, err)
+			}
+			if tt.wantE != g.E {
+				t.Errorf("want error code value %d, got %d", tt.wantE, g.E)
+			}
+		})
+	}
+}
+
+func TestMemoryAccessExceptions_ForVariableOperands(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		program []gmachine.Word
+	}{
+		{"MVAV outside memory", []gmachine.Word{gmachine.OpMVAV, 768}},
+		{"MVVA outside memory", []gmachine.Word{gmachine.OpMVVA, 0xFFFFFFFFFFFFFFFF}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := gmachine.New()
+			g.RunProgram(tt.program)
+			var wantE = gmachine.ExceptionInvalidAddress
+			if wantE != g.E {
+				t.Errorf("want error code value %d, got %d", wantE, g.E)
+			}
+		})
+	}
+}
+
+func TestRunProgram_RaisesOutOfMemoryForOversizedProgram(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New(gmachine.WithMemorySize(4), gmachine.WithStackSize(2))
+	g.RunProgram([]gmachine.Word{gmachine.OpNOOP, gmachine.OpNOOP, gmachine.OpHALT})
+	var wantE = gmachine.ExceptionOutOfMemory
+	if wantE != g.E {
+		t.Errorf("want error code value %d, got %d", wantE, g.E)
+	}
+}
+
+func TestRunProgram_RaisesOutOfMemoryWhenStackExceedsMemory(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New(gmachine.WithMemorySize(4), gmachine.WithStackSize(8))
+	g.RunProgram([]gmachine.Word{gmachine.OpHALT})
 	var wantE = gmachine.ExceptionOutOfMemory
 	if wantE != g.E {
 		t.Errorf("want error code value %d, got %d", wantE, g.E)
 	}
 }
 
+func FuzzRunProgram(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{byte(gmachine.OpPOPA)})
+	f.Add([]byte{byte(gmachine.OpRTRN)})
+	f.Add([]byte{byte(gmachine.OpJUMP), 0xFF})
+	f.Add([]byte{byte(gmachine.OpSETA), 0xFF, byte(gmachine.OpMVIAX)})
+	f.Add([]byte{byte(gmachine.OpMVAV), 0xFF, byte(gmachine.OpMVVA), 0xFF})
+	f.Add([]byte{byte(gmachine.OpPSHA), byte(gmachine.OpJUMP), 0})
+	f.Add([]byte{byte(gmachine.OpCALL), 0})
+	f.Add([]byte{byte(gmachine.OpDIVAI), 0, byte(gmachine.OpMODA), 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Scale each byte into a word so that small values hit opcodes and
+		// large ones produce hostile addresses.
+		program := make([]gmachine.Word, len(data))
+		for i, b := range data {
+			program[i] = gmachine.Word(b)
+			if b >= 0xF0 {
+				program[i] = ^gmachine.Word(0) - gmachine.Word(0xFF-b)
+			}
+		}
+		g := gmachine.New(
+			gmachine.WithMemorySize(64),
+			gmachine.WithStackSize(8),
+			gmachine.WithInstructionLimit(1000),
+		)
+		g.RunProgram(program)
+	})
+}
+
 func TestDECA(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	g.A = 1
 	var wantA gmachine.Word = 0
-	err := assembleAndRunFromString(g, "DECA")
+	err := assembleAndRunFromString(g, "DECA\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error", err)
 	}
@@ -172,10 +562,10 @@ func TestDECA(t *testing.T) {
 
 func TestDECX(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	g.X = 1
 	var wantX gmachine.Word = 0
-	err := assembleAndRunFromString(g, "DECX")
+	err := assembleAndRunFromString(g, "DECX\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error", err)
 	}
@@ -186,10 +576,10 @@ func TestDECX(t *testing.T) {
 
 func TestDECY(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	g.Y = 1
 	var wantY gmachine.Word = 0
-	err := assembleAndRunFromString(g, "DECY")
+	err := assembleAndRunFromString(g, "DECY\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error", err)
 	}
@@ -200,9 +590,9 @@ func TestDECY(t *testing.T) {
 
 func TestSETA(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	var wantA gmachine.Word = 5
-	err := assembleAndRunFromString(g, "SETA 5")
+	err := assembleAndRunFromString(g, "SETA 5\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error", err)
 	}
@@ -213,9 +603,9 @@ func TestSETA(t *testing.T) {
 
 func TestSETX(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	var wantX gmachine.Word = 5
-	err := assembleAndRunFromString(g, "SETX 5")
+	err := assembleAndRunFromString(g, "SETX 5\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error", err)
 	}
@@ -226,9 +616,9 @@ func TestSETX(t *testing.T) {
 
 func TestSETY(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	var wantY gmachine.Word = 5
-	err := assembleAndRunFromString(g, "SETY 5")
+	err := assembleAndRunFromString(g, "SETY 5\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error", err)
 	}
@@ -261,7 +651,7 @@ func TestAssemble_ReturnsErrorForUnknownInstruction(t *testing.T) {
 
 func TestAssembleAndRun(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	err := assembleAndRunFromString(g, "INCA\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error", err)
@@ -274,7 +664,7 @@ func TestAssembleAndRun(t *testing.T) {
 
 func TestSETA_ReturnsErrorForInvalidNumber(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	err := assembleAndRunFromString(g, "SETA 2a")
 	wantErr := parser.ErrInvalidIntegerLiteral
 	if err == nil {
@@ -287,7 +677,7 @@ func TestSETA_ReturnsErrorForInvalidNumber(t *testing.T) {
 
 func TestSETX_ReturnsErrorForInvalidNumber(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	err := assembleAndRunFromString(g, "SETX 2a")
 	wantErr := parser.ErrInvalidIntegerLiteral
 	if err == nil {
@@ -300,7 +690,7 @@ func TestSETX_ReturnsErrorForInvalidNumber(t *testing.T) {
 
 func TestSETY_ReturnsErrorForInvalidNumber(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	err := assembleAndRunFromString(g, "SETY 2a")
 	wantErr := parser.ErrInvalidIntegerLiteral
 	if err == nil {
@@ -311,10 +701,94 @@ func TestSETY_ReturnsErrorForInvalidNumber(t *testing.T) {
 	}
 }
 
+func TestSETA_AcceptsNumericLiteralForms(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input string
+		want  gmachine.Word
+	}{
+		{"SETA -1\nHALT", 1<<64 - 1},
+		{"SETA 0xFF\nHALT", 255},
+		{"SETA 0b101 ; five\nHALT", 5},
+		{"SETA 5 ; note\nHALT", 5},
+		{"SETA 3\nSUBA -1\nHALT", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			g := gmachine.New()
+			err := assembleAndRunFromString(g, tt.input)
+			if err != nil {
+				t.Fatal("didn't expect an error:", err)
+			}
+			if tt.want != g.A {
+				t.Errorf("want A %d, got %d", tt.want, g.A)
+			}
+		})
+	}
+}
+
+func TestSETA_AcceptsEscapedCharacterLiterals(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input string
+		want  gmachine.Word
+	}{
+		{`SETA '\n'`, '\n'},
+		{`SETA '\''`, '\''},
+		{`SETA '\\'`, '\\'},
+		{`SETA '\0'`, 0},
+		{`SETA '\x7f'`, 0x7f},
+		{`SETA '\u{263A}'`, '☺'},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			g := gmachine.New()
+			err := assembleAndRunFromString(g, tt.input+"\nHALT")
+			if err != nil {
+				t.Fatal("didn't expect an error:", err)
+			}
+			if tt.want != g.A {
+				t.Errorf("want A %d, got %d", tt.want, g.A)
+			}
+		})
+	}
+}
+
+func TestVARB_StoresEscapedStringLiteral(t *testing.T) {
+	t.Parallel()
+	program, err := assembleFromString(`VARB s "say \"hi\"\t\n"`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	want := []gmachine.Word{'s', 'a', 'y', ' ', '"', 'h', 'i', '"', '\t', '\n', 0}
+	if !cmp.Equal(want, program) {
+		t.Error(cmp.Diff(want, program))
+	}
+}
+
+func TestAssemble_ReturnsErrorForUnterminatedLiterals(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input   string
+		wantErr error
+	}{
+		{"SETA '\nHALT", lexer.ErrInvalidCharacterLiteral},
+		{"VARB s \"abc\nHALT", lexer.ErrInvalidStringLiteral},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := assembleFromString(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("wanted error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
 func TestSETA_AcceptsCharacterLiteral(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
-	err := assembleAndRunFromString(g, "SETA 'h'")
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, "SETA 'h'\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
 	}
@@ -327,8 +801,8 @@ func TestSETA_AcceptsCharacterLiteral(t *testing.T) {
 
 func TestSETX_AcceptsCharacterLiteral(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
-	err := assembleAndRunFromString(g, "SETX 'h'")
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, "SETX 'h'\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
 	}
@@ -341,8 +815,8 @@ func TestSETX_AcceptsCharacterLiteral(t *testing.T) {
 
 func TestSETY_AcceptsCharacterLiteral(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
-	err := assembleAndRunFromString(g, "SETY 'h'")
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, "SETY 'h'\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
 	}
@@ -358,8 +832,8 @@ func TestOUTA_SerializesValueAsBytesInBigEndianOrder(t *testing.T) {
 
 	var buf bytes.Buffer
 	out := io.Writer(&buf)
-	g := gmachine.New(out)
-	err := assembleAndRunFromString(g, "SETA 1\nOUTA")
+	g := gmachine.New(gmachine.WithOutput(out))
+	err := assembleAndRunFromString(g, "SETA 1\nOUTA\nHALT")
 	if err != nil {
 		t.Fatalf("didn't expect an error: %v", err)
 	}
@@ -375,7 +849,7 @@ func TestOUTA(t *testing.T) {
 	t.Parallel()
 	var buf bytes.Buffer
 	out := io.Writer(&buf)
-	g := gmachine.New(out)
+	g := gmachine.New(gmachine.WithOutput(out))
 	err := assembleAndRunFromString(g, `
 SETA 'h'
 OUTA
@@ -400,7 +874,8 @@ OUTA
 SETA 'd'
 OUTA
 SETA '!'
-OUTA`)
+OUTA
+HALT`)
 	if err != nil {
 		t.Fatal("didn't expect an error", err)
 	}
@@ -420,53 +895,261 @@ OUTA`)
 	}
 }
 
-func TestJUMP(t *testing.T) {
+func TestOUTC_WritesAccumulatorAsUTF8Rune(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
-	var wantA gmachine.Word = 42
+	var buf bytes.Buffer
+	g := gmachine.New(gmachine.WithOutput(&buf))
 	err := assembleAndRunFromString(g, `
-JUMP 3
+SETA 'h'
+OUTC
+SETA 'i'
+OUTC
+SETA 0x263A
+OUTC
 HALT
-SETA 41
-INCA
 `)
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
 	}
-	if wantA != g.A {
-		t.Errorf("want A %d, got %d", wantA, g.A)
+	want := "hi☺"
+	got := buf.String()
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
 	}
 }
 
-func TestJUMPWithInvalidNumber(t *testing.T) {
+func TestOUTC_WritesReplacementCharacterForInvalidRunes(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
-	err := assembleAndRunFromString(g, "JUMP 2a")
-	wantErr := parser.ErrInvalidIntegerLiteral
-	if err == nil {
-		t.Fatal("expected an error to be returned for invalid argument to JUMP")
+	tests := []struct {
+		program string
+		want    string
+	}{
+		{"SETA 0x10FFFF\nOUTC", "\U0010FFFF"},
+		{"SETA 0x110000\nOUTC", "\uFFFD"},
+		{"SETA 0x100000041\nOUTC", "\uFFFD"},
+		{"SETA -1\nOUTC", "\uFFFD"},
+		{"SETA 0xD800\nOUTC", "\uFFFD"},
 	}
-	if !errors.Is(err, wantErr) {
-		t.Errorf("wanted error %v, got %v", wantErr, err)
+	for _, tt := range tests {
+		t.Run(tt.program, func(t *testing.T) {
+			var buf bytes.Buffer
+			g := gmachine.New(gmachine.WithOutput(&buf))
+			err := assembleAndRunFromString(g, tt.program+"\nHALT")
+			if err != nil {
+				t.Fatal("didn't expect an error:", err)
+			}
+			got := buf.String()
+			if tt.want != got {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
 	}
 }
 
-func TestJXNZ(t *testing.T) {
+func TestOUTN_WritesAccumulatorAsDecimalNumber(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
-	var wantA gmachine.Word = 10
-	var wantX gmachine.Word = 0
+	tests := []struct {
+		program string
+		want    string
+	}{
+		{"SETA 0\nOUTN", "0"},
+		{"SETA 42\nOUTN", "42"},
+		{"SETA 720\nOUTN\nSETA ' '\nOUTC\nSETA 5040\nOUTN", "720 5040"},
+		{"SETA 0xFFFFFFFFFFFFFFFF\nOUTN", "18446744073709551615"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			var buf bytes.Buffer
+			g := gmachine.New(gmachine.WithOutput(&buf))
+			err := assembleAndRunFromString(g, tt.program+"\nHALT")
+			if err != nil {
+				t.Fatal("didn't expect an error:", err)
+			}
+			got := buf.String()
+			if tt.want != got {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestINA_ReadsNextCharacterFromInput(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New(gmachine.WithInput(strings.NewReader("hi")))
 	err := assembleAndRunFromString(g, `
-SETA 0
-SETX 10
-.loop
-INCA
-DECX
-JXNZ loop
+INA
+MOVE A -> X
+INA
+MOVE A -> Y
+INA
 HALT
 `)
 	if err != nil {
-		t.Fatal("didn't expect an error", err)
+		t.Fatal("didn't expect an error:", err)
+	}
+	var wantX gmachine.Word = 'h'
+	if wantX != g.X {
+		t.Errorf("want X %d, got %d", wantX, g.X)
+	}
+	var wantY gmachine.Word = 'i'
+	if wantY != g.Y {
+		t.Errorf("want Y %d, got %d", wantY, g.Y)
+	}
+	var wantA = gmachine.EOF
+	if wantA != g.A {
+		t.Errorf("want A %d, got %d", wantA, g.A)
+	}
+}
+
+func TestINA_ReturnsEOFWithoutInput(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, "INA\nHALT")
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var wantA = gmachine.EOF
+	if wantA != g.A {
+		t.Errorf("want A %d, got %d", wantA, g.A)
+	}
+}
+
+func TestINA_EchoesInputToOutput(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	g := gmachine.New(
+		gmachine.WithInput(strings.NewReader("ok")),
+		gmachine.WithOutput(&buf),
+	)
+	err := assembleAndRunFromString(g, `
+.loop
+INA
+PSHA
+NOTA
+JAZ done
+POPA
+OUTA
+JUMP loop
+.done
+POPA
+HALT
+`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	wantBuf := bytes.Buffer{}
+	binary.Write(&wantBuf, binary.BigEndian, []uint64{'o', 'k'})
+	want := wantBuf.Bytes()
+	got := buf.Bytes()
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestINA_ReadsUTF8EncodedCharacters(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input string
+		want  []gmachine.Word
+	}{
+		{"é", []gmachine.Word{'é', gmachine.EOF}},
+		{"a€😀", []gmachine.Word{'a', '€', '😀', gmachine.EOF}},
+		{"\xffa", []gmachine.Word{utf8.RuneError, 'a', gmachine.EOF}},
+		{"\xe2\x82", []gmachine.Word{utf8.RuneError, gmachine.EOF}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			g := gmachine.New(gmachine.WithInput(strings.NewReader(tt.input)))
+			var got []gmachine.Word
+			for range tt.want {
+				g.P = 0
+				err := g.RunProgram([]gmachine.Word{gmachine.OpINA, gmachine.OpHALT})
+				if err != nil {
+					t.Fatal("didn't expect an error:", err)
+				}
+				got = append(got, g.A)
+			}
+			if !cmp.Equal(tt.want, got) {
+				t.Error(cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func TestINA_EchoesNonASCIITextWithOUTC(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	g := gmachine.New(
+		gmachine.WithInput(strings.NewReader("héllo, 世界")),
+		gmachine.WithOutput(&buf),
+	)
+	err := assembleAndRunFromString(g, `
+.loop
+INA
+CMPA -1
+JEQ done
+OUTC
+JUMP loop
+.done
+HALT
+`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	want := "héllo, 世界"
+	if want != buf.String() {
+		t.Errorf("want %q, got %q", want, buf.String())
+	}
+}
+
+func TestJUMP(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	var wantA gmachine.Word = 42
+	err := assembleAndRunFromString(g, `
+JUMP 3
+HALT
+SETA 41
+INCA
+HALT
+`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	if wantA != g.A {
+		t.Errorf("want A %d, got %d", wantA, g.A)
+	}
+}
+
+func TestJUMPWithInvalidNumber(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, "JUMP 2a")
+	wantErr := parser.ErrInvalidIntegerLiteral
+	if err == nil {
+		t.Fatal("expected an error to be returned for invalid argument to JUMP")
+	}
+	if !errors.Is(err, wantErr) {
+		t.Errorf("wanted error %v, got %v", wantErr, err)
+	}
+}
+
+func TestJXNZ(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	var wantA gmachine.Word = 10
+	var wantX gmachine.Word = 0
+	err := assembleAndRunFromString(g, `
+SETA 0
+SETX 10
+.loop
+INCA
+DECX
+JXNZ loop
+HALT
+`)
+	if err != nil {
+		t.Fatal("didn't expect an error", err)
 	}
 	if wantA != g.A {
 		t.Errorf("want A value %d, got %d", wantA, g.A)
@@ -492,7 +1175,7 @@ func TestFactorial(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(fmt.Sprintf("%d!", tt.factorial), func(t *testing.T) {
-			g := gmachine.New(nil)
+			g := gmachine.New()
 			program := fmt.Sprintf(`
 SETA 1
 SETX %d
@@ -513,6 +1196,286 @@ HALT
 	}
 }
 
+func TestCALL_AssemblesTargetAddressOperand(t *testing.T) {
+	t.Parallel()
+	want := []gmachine.Word{
+		gmachine.OpCALL,
+		gmachine.Word(3),
+		gmachine.OpHALT,
+		// .sub
+		gmachine.OpINCA,
+		gmachine.OpRTRN,
+	}
+	got, err := assembleFromString(`
+CALL sub
+HALT
+.sub
+INCA
+RTRN
+`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestCALL_PushesReturnAddressOntoStack(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, `
+CALL sub
+.sub
+HALT
+`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var wantS gmachine.Word = 1
+	if wantS != g.S {
+		t.Errorf("want S %d, got %d", wantS, g.S)
+	}
+	var wantReturn gmachine.Word = 2
+	if wantReturn != g.Memory[0] {
+		t.Errorf("want return address %d, got %d", wantReturn, g.Memory[0])
+	}
+}
+
+func TestRTRN_ResumesExecutionAfterCall(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, `
+SETA 1
+CALL double
+INCA
+HALT
+.double
+MOVE A -> X
+ADDA X
+RTRN
+`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var wantA gmachine.Word = 3
+	if wantA != g.A {
+		t.Errorf("want A %d, got %d", wantA, g.A)
+	}
+	var wantS gmachine.Word = 0
+	if wantS != g.S {
+		t.Errorf("want S %d, got %d", wantS, g.S)
+	}
+}
+
+func TestCALL_SupportsNestedCalls(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, `
+CALL outer
+INCA
+HALT
+.outer
+INCA
+CALL inner
+INCA
+RTRN
+.inner
+SETY 42
+RTRN
+`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var wantA gmachine.Word = 3
+	if wantA != g.A {
+		t.Errorf("want A %d, got %d", wantA, g.A)
+	}
+	var wantY gmachine.Word = 42
+	if wantY != g.Y {
+		t.Errorf("want Y %d, got %d", wantY, g.Y)
+	}
+	var wantS gmachine.Word = 0
+	if wantS != g.S {
+		t.Errorf("want S %d, got %d", wantS, g.S)
+	}
+}
+
+func TestCALL_SupportsRecursiveCalls(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		depth gmachine.Word
+		wantA gmachine.Word
+	}{
+		{1, 1},
+		{2, 2},
+		{5, 5},
+		{10, 10},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("depth %d", tt.depth), func(t *testing.T) {
+			g := gmachine.New()
+			program := fmt.Sprintf(`
+SETX %d
+CALL count
+HALT
+.count
+INCA
+DECX
+JXNZ recurse
+RTRN
+.recurse
+CALL count
+RTRN
+`, tt.depth)
+			err := assembleAndRunFromString(g, program)
+			if err != nil {
+				t.Fatal("didn't expect an error:", err)
+			}
+			if tt.wantA != g.A {
+				t.Errorf("want A %d, got %d", tt.wantA, g.A)
+			}
+			var wantS gmachine.Word = 0
+			if wantS != g.S {
+				t.Errorf("want S %d, got %d", wantS, g.S)
+			}
+		})
+	}
+}
+
+func TestCMPA_SetsFlags(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		program string
+		wantF   gmachine.Word
+	}{
+		{"equal immediate", "SETA 5\nCMPA 5", gmachine.FlagZero},
+		{"greater immediate", "SETA 6\nCMPA 5", 0},
+		{"less immediate", "SETA 4\nCMPA 5", gmachine.FlagCarry | gmachine.FlagNegative},
+		{"character immediate", "SETA 'a'\nCMPA 'a'", gmachine.FlagZero},
+		{"equal register X", "SETA 5\nSETX 5\nCMPA X", gmachine.FlagZero},
+		{"less register Y", "SETA 1\nSETY 2\nCMPA Y", gmachine.FlagCarry | gmachine.FlagNegative},
+		{"negative greater unsigned", "SETA -1\nCMPA 1", gmachine.FlagNegative},
+		{"overflow", "SETA 0x7fffffffffffffff\nCMPA -1", gmachine.FlagCarry | gmachine.FlagNegative | gmachine.FlagOverflow},
+		{"constant", "CONS c 3\nSETA 3\nCMPA c", gmachine.FlagZero},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := gmachine.New()
+			err := assembleAndRunFromString(g, tt.program+"\nHALT")
+			if err != nil {
+				t.Fatal("didn't expect an error:", err)
+			}
+			if tt.wantF != g.F {
+				t.Errorf("want F %04b, got %04b", tt.wantF, g.F)
+			}
+		})
+	}
+}
+
+func TestCMPA_AssemblesImmediateOperand(t *testing.T) {
+	t.Parallel()
+	want := []gmachine.Word{
+		gmachine.OpCMPAI,
+		gmachine.Word(5),
+		gmachine.OpCMPA,
+		gmachine.RegX,
+	}
+	got, err := assembleFromString("CMPA 5\nCMPA X")
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestConditionalJumps(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		setup    string
+		jump     string
+		wantJump bool
+	}{
+		{"SETA 5\nCMPA 5", "JZ", true},
+		{"SETA 5\nCMPA 4", "JZ", false},
+		{"SETA 5\nCMPA 4", "JNZ", true},
+		{"SETA 5\nCMPA 5", "JNZ", false},
+		{"SETA 5\nCMPA 5", "JEQ", true},
+		{"SETA 5\nCMPA 6", "JEQ", false},
+		{"SETA 4\nCMPA 5", "JLT", true},
+		{"SETA 5\nCMPA 5", "JLT", false},
+		{"SETA 6\nCMPA 5", "JLT", false},
+		{"SETA 6\nCMPA 5", "JGT", true},
+		{"SETA 5\nCMPA 5", "JGT", false},
+		{"SETA 4\nCMPA 5", "JGT", false},
+		{"SETA -1\nCMPA 1", "JGT", true},
+		{"SETA 1\nCMPA -1", "JLT", true},
+		{"SETA -1\nCMPA 1", "JSLT", true},
+		{"SETA 1\nCMPA -1", "JSLT", false},
+		{"SETA 5\nCMPA 5", "JSLT", false},
+		{"SETA 0x7fffffffffffffff\nCMPA -1", "JSLT", false},
+		{"SETA -0x8000000000000000\nCMPA 1", "JSLT", true},
+		{"SETA 1\nCMPA -1", "JSGT", true},
+		{"SETA -1\nCMPA 1", "JSGT", false},
+		{"SETA 5\nCMPA 5", "JSGT", false},
+		{"SETA 0x7fffffffffffffff\nCMPA -1", "JSGT", true},
+		{"SETA 1", "JANZ", true},
+		{"SETA 0", "JANZ", false},
+		{"SETA 0", "JAZ", true},
+		{"SETA 1", "JAZ", false},
+		{"SETY 1", "JYNZ", true},
+		{"SETY 0", "JYNZ", false},
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s %s", strings.ReplaceAll(tt.setup, "\n", " "), tt.jump)
+		t.Run(name, func(t *testing.T) {
+			g := gmachine.New()
+			program := fmt.Sprintf(`
+%s
+%s taken
+SETX 1
+HALT
+.taken
+SETX 2
+HALT
+`, tt.setup, tt.jump)
+			err := assembleAndRunFromString(g, program)
+			if err != nil {
+				t.Fatal("didn't expect an error:", err)
+			}
+			var wantX gmachine.Word = 1
+			if tt.wantJump {
+				wantX = 2
+			}
+			if wantX != g.X {
+				t.Errorf("want X %d, got %d", wantX, g.X)
+			}
+		})
+	}
+}
+
+func TestJANZ_LoopsUntilAccumulatorIsZero(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, `
+SETA 5
+.loop
+INCX
+DECA
+JANZ loop
+HALT
+`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var wantX gmachine.Word = 5
+	if wantX != g.X {
+		t.Errorf("want X %d, got %d", wantX, g.X)
+	}
+}
+
 func TestAssemble_SkipsComments(t *testing.T) {
 	t.Parallel()
 	want := []gmachine.Word{}
@@ -527,11 +1490,11 @@ func TestAssemble_SkipsComments(t *testing.T) {
 
 func TestPSHA(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	var wantA gmachine.Word = 42
 	var wantS gmachine.Word = 1
 	var want gmachine.Word = 42
-	err := assembleAndRunFromString(g, "SETA 42\nPSHA")
+	err := assembleAndRunFromString(g, "SETA 42\nPSHA\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
 	}
@@ -548,7 +1511,7 @@ func TestPSHA(t *testing.T) {
 
 func TestPOPA(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	var wantA gmachine.Word = 42
 	var wantS gmachine.Word = 0
 	err := assembleAndRunFromString(g, `
@@ -556,6 +1519,7 @@ SETA 42
 PSHA
 SETA 3
 POPA
+HALT
 `)
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
@@ -570,9 +1534,9 @@ POPA
 
 func TestMOVE_CopiesRegisterAToRegisterX(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	var wantX gmachine.Word = 42
-	err := assembleAndRunFromString(g, "SETA 42\nMOVE A -> X\n")
+	err := assembleAndRunFromString(g, "SETA 42\nMOVE A -> X\n\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
 	}
@@ -583,9 +1547,9 @@ func TestMOVE_CopiesRegisterAToRegisterX(t *testing.T) {
 
 func TestMOVE_CopiesRegisterAToRegisterY(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	var wantY gmachine.Word = 42
-	err := assembleAndRunFromString(g, "SETA 42\nMOVE A -> Y\n")
+	err := assembleAndRunFromString(g, "SETA 42\nMOVE A -> Y\n\nHALT")
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
 	}
@@ -596,7 +1560,7 @@ func TestMOVE_CopiesRegisterAToRegisterY(t *testing.T) {
 
 func TestMOVE_FailsForUnknownIdentifier(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	err := assembleAndRunFromString(g, "MOVE A -> Z")
 	wantErr := gmachine.ErrUnknownIdentifier
 	if err == nil {
@@ -609,13 +1573,14 @@ func TestMOVE_FailsForUnknownIdentifier(t *testing.T) {
 
 func TestMOVE_CopiesDereferencedRegisterAToRegisterX(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	err := assembleAndRunFromString(g, `
 JUMP start
 VARB num 42
 .start
 SETA num
 MOVE *A -> X
+HALT
 `)
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
@@ -628,13 +1593,14 @@ MOVE *A -> X
 
 func TestADDAX(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	var wantA gmachine.Word = 10
 	err := assembleAndRunFromString(g, `
 SETA 6
 MOVE A -> X
 SETA 4
 ADDA X
+HALT
 `)
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
@@ -646,13 +1612,14 @@ ADDA X
 
 func TestADDAY(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	var wantA gmachine.Word = 10
 	err := assembleAndRunFromString(g, `
 SETA 6
 MOVE A -> Y
 SETA 4
 ADDA Y
+HALT
 `)
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
@@ -664,7 +1631,7 @@ ADDA Y
 
 func TestADDA_FailsForInvalidRegister(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	err := assembleAndRunFromString(g, "ADDA Z")
 	wantErr := gmachine.ErrInvalidOperand
 	if err == nil {
@@ -677,7 +1644,7 @@ func TestADDA_FailsForInvalidRegister(t *testing.T) {
 
 func TestAddTwoNumbers(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	err := assembleAndRunFromString(g, `
 ; x = 4, y = 6
 SETA 4
@@ -689,6 +1656,7 @@ POPA
 MOVE A -> X
 POPA
 ADDA X
+HALT
 `)
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
@@ -701,12 +1669,13 @@ ADDA X
 
 func TestMULAX(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	err := assembleAndRunFromString(g, `
 SETA 5
 MOVE A -> X
 SETA 2
-MULA X`)
+MULA X
+HALT`)
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
 	}
@@ -718,12 +1687,13 @@ MULA X`)
 
 func TestMULAY(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	err := assembleAndRunFromString(g, `
 SETA 5
 MOVE A -> Y
 SETA 2
-MULA Y`)
+MULA Y
+HALT`)
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
 	}
@@ -733,43 +1703,313 @@ MULA Y`)
 	}
 }
 
-func TestSubroutineLabel(t *testing.T) {
+func TestArithmetic(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		program string
+		wantA   gmachine.Word
+	}{
+		{"SETA 10\nSETX 3\nSUBA X", 7},
+		{"SETA 10\nSETY 4\nSUBA Y", 6},
+		{"SETA 10\nSUBA 1", 9},
+		{"SETA 0\nSUBA 1", 0xFFFFFFFFFFFFFFFF},
+		{"SETA 10\nSETX 3\nDIVA X", 3},
+		{"SETA 10\nSETY 5\nDIVA Y", 2},
+		{"SETA 10\nDIVA 4", 2},
+		{"SETA 10\nSETX 3\nMODA X", 1},
+		{"SETA 10\nSETY 5\nMODA Y", 0},
+		{"SETA 10\nMODA 4", 2},
+		{"CONS c 2\nSETA 10\nDIVA c", 5},
+	}
+	for _, tt := range tests {
+		t.Run(strings.ReplaceAll(tt.program, "\n", " "), func(t *testing.T) {
+			g := gmachine.New()
+			err := assembleAndRunFromString(g, tt.program+"\nHALT")
+			if err != nil {
+				t.Fatal("didn't expect an error:", err)
+			}
+			if tt.wantA != g.A {
+				t.Errorf("want A %d, got %d", tt.wantA, g.A)
+			}
+		})
+	}
+}
+
+func TestArithmetic_AssemblesRegisterAndImmediateForms(t *testing.T) {
+	t.Parallel()
+	want := []gmachine.Word{
+		gmachine.OpSUBA, gmachine.RegX,
+		gmachine.OpSUBAI, gmachine.Word(1),
+		gmachine.OpDIVA, gmachine.RegY,
+		gmachine.OpDIVAI, gmachine.Word(2),
+		gmachine.OpMODA, gmachine.RegX,
+		gmachine.OpMODAI, gmachine.Word(3),
+	}
+	got, err := assembleFromString(`
+SUBA X
+SUBA 1
+DIVA Y
+DIVA 2
+MODA X
+MODA 3
+`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestDivideByZeroException(t *testing.T) {
+	t.Parallel()
+	tests := []string{
+		"SETA 1\nDIVA X",
+		"SETA 1\nDIVA 0",
+		"SETA 1\nMODA Y",
+		"SETA 1\nMODA 0",
+	}
+	for _, program := range tests {
+		t.Run(strings.ReplaceAll(program, "\n", " "), func(t *testing.T) {
+			g := gmachine.New()
+			err := assembleAndRunFromString(g, program)
+			var wantE = gmachine.ExceptionDivideByZero
+			if !errors.Is(err, wantE) {
+				t.Errorf("want error %v, got %v", wantE, err)
+			}
+			if wantE != g.E {
+				t.Errorf("want error code value %d, got %d", wantE, g.E)
+			}
+			var wantA gmachine.Word = 1
+			if wantA != g.A {
+				t.Errorf("want A %d, got %d", wantA, g.A)
+			}
+		})
+	}
+}
+
+func TestBitwise(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		program string
+		wantA   gmachine.Word
+	}{
+		{"SETA 12\nSETX 10\nANDA X", 8},
+		{"SETA 12\nSETY 10\nANDA Y", 8},
+		{"SETA 0xFF\nANDA 0x0F", 0x0F},
+		{"SETA 12\nSETX 10\nORA X", 14},
+		{"SETA 0xF0\nORA 0x0F", 0xFF},
+		{"SETA 12\nSETX 10\nXORA X", 6},
+		{"SETA 0xFF\nXORA 0x0F", 0xF0},
+		{"SETA 0\nNOTA", 0xFFFFFFFFFFFFFFFF},
+		{"SETA 0xFFFFFFFFFFFFFFF0\nNOTA", 0x0F},
+		{"SETA 1\nSETX 4\nSHLA X", 16},
+		{"SETA 1\nSHLA 63", 0x8000000000000000},
+		{"SETA 1\nSHLA 64", 0},
+		{"SETA 16\nSETY 4\nSHRA Y", 1},
+		{"SETA 0x8000000000000000\nSHRA 63", 1},
+		{"SETA 0x8000000000000000\nSETX 1\nROLA X", 1},
+		{"SETA 0x8000000000000001\nROLA 4", 0x18},
+		{"SETA 1\nROLA 64", 1},
+		{"SETA 1\nSETY 1\nRORA Y", 0x8000000000000000},
+		{"SETA 0x18\nRORA 4", 0x8000000000000001},
+	}
+	for _, tt := range tests {
+		t.Run(strings.ReplaceAll(tt.program, "\n", " "), func(t *testing.T) {
+			g := gmachine.New()
+			err := assembleAndRunFromString(g, tt.program+"\nHALT")
+			if err != nil {
+				t.Fatal("didn't expect an error:", err)
+			}
+			if tt.wantA != g.A {
+				t.Errorf("want A %#x, got %#x", tt.wantA, g.A)
+			}
+		})
+	}
+}
+
+func TestBitwise_AssemblesRegisterAndImmediateForms(t *testing.T) {
+	t.Parallel()
+	want := []gmachine.Word{
+		gmachine.OpANDA, gmachine.RegX,
+		gmachine.OpANDAI, gmachine.Word(1),
+		gmachine.OpORA, gmachine.RegY,
+		gmachine.OpORAI, gmachine.Word(2),
+		gmachine.OpXORA, gmachine.RegX,
+		gmachine.OpXORAI, gmachine.Word(3),
+		gmachine.OpNOTA,
+		gmachine.OpSHLA, gmachine.RegY,
+		gmachine.OpSHLAI, gmachine.Word(4),
+		gmachine.OpSHRA, gmachine.RegX,
+		gmachine.OpSHRAI, gmachine.Word(5),
+		gmachine.OpROLA, gmachine.RegY,
+		gmachine.OpROLAI, gmachine.Word(6),
+		gmachine.OpRORA, gmachine.RegX,
+		gmachine.OpRORAI, gmachine.Word(7),
+	}
+	got, err := assembleFromString(`
+ANDA X
+ANDA 1
+ORA Y
+ORA 2
+XORA X
+XORA 3
+NOTA
+SHLA Y
+SHLA 4
+SHRA X
+SHRA 5
+ROLA Y
+ROLA 6
+RORA X
+RORA 7
+`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestSubroutineLabel(t *testing.T) {
+	t.Parallel()
+	want := []gmachine.Word{gmachine.OpSETA, gmachine.Word(42), gmachine.OpOUTA}
+	got, err := assembleFromString(`
+.test
+SETA 42
+OUTA
+`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestAssemble_ReturnsErrorWhenGivenAnUndefinedIdentifer(t *testing.T) {
+	t.Parallel()
+	wantErr := gmachine.ErrUnknownIdentifier
+	_, err := assembleFromString("JUMP foo")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !errors.Is(err, wantErr) {
+		t.Errorf("wanted error %v, got %v", wantErr, err)
+	}
+}
+
+func TestAssemble_ReturnsErrorWhenJumpIsPassedInvalidArgument(t *testing.T) {
+	t.Parallel()
+	wantErr := gmachine.ErrInvalidOperand
+	_, err := assembleFromString("JUMP 'a'")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !errors.Is(err, wantErr) {
+		t.Errorf("wanted error %v, got %v", wantErr, err)
+	}
+}
+
+func TestAssemble_ReportsEveryErrorWithItsPosition(t *testing.T) {
 	t.Parallel()
-	want := []gmachine.Word{gmachine.OpSETA, gmachine.Word(42), gmachine.OpOUTA}
-	got, err := assembleFromString(`
-.test
-SETA 42
-OUTA
-`)
-	if err != nil {
-		t.Fatal("didn't expect an error:", err)
+	_, err := assembleFromString(`SETA 1
+SETX 2a
+JUMP nowhere
+  @
+CALL elsewhere
+HALT`)
+	var errs lexer.ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("want a lexer.ErrorList, got %v", err)
+	}
+	type position struct{ Line, Column int }
+	want := []position{{2, 6}, {3, 6}, {4, 3}, {5, 6}}
+	var got []position
+	for _, e := range errs {
+		got = append(got, position{e.Pos.Line, e.Pos.Column})
 	}
 	if !cmp.Equal(want, got) {
 		t.Error(cmp.Diff(want, got))
 	}
+	for _, wantErr := range []error{
+		parser.ErrInvalidIntegerLiteral,
+		gmachine.ErrUnknownIdentifier,
+		lexer.ErrIllegalCharacter,
+	} {
+		if !errors.Is(err, wantErr) {
+			t.Errorf("wanted error %v, got %v", wantErr, err)
+		}
+	}
 }
 
-func TestAssemble_ReturnsErrorWhenGivenAnUndefinedIdentifer(t *testing.T) {
+func TestAssemble_RejectsInstructionsWithWrongNumberOfOperands(t *testing.T) {
 	t.Parallel()
-	wantErr := gmachine.ErrUnknownIdentifier
-	_, err := assembleFromString("JUMP foo")
+	for _, input := range []string{"INCA 5", "OUTC 'x'", "SETA", "JUMP", "ADDA"} {
+		t.Run(input, func(t *testing.T) {
+			_, err := assembleFromString(input)
+			wantErr := gmachine.ErrInvalidOperand
+			if !errors.Is(err, wantErr) {
+				t.Errorf("wanted error %v, got %v", wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAssemble_RejectsGarbageInput(t *testing.T) {
+	t.Parallel()
+	f, err := os.Open("examples/hello-invalid.g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	program, err := gmachine.Assemble(f)
 	if err == nil {
-		t.Fatal("expected an error")
+		t.Fatalf("expected an error, got program %v", program)
 	}
+	_, err = assembleFromString("HALT\nthis is not a program")
+	wantErr := parser.ErrInvalidSyntax
 	if !errors.Is(err, wantErr) {
 		t.Errorf("wanted error %v, got %v", wantErr, err)
 	}
 }
 
-func TestAssemble_ReturnsErrorWhenJumpIsPassedInvalidArgument(t *testing.T) {
+func TestAssemble_IncludesFilenameInErrorsWhenGiven(t *testing.T) {
 	t.Parallel()
-	wantErr := gmachine.ErrInvalidOperand
-	_, err := assembleFromString("JUMP 'a'")
+	_, err := gmachine.Assemble(strings.NewReader("HALT\nSETA 1\n  JUMP nowhere"), gmachine.WithFilename("test.g"))
+	want := "test.g:3:8: missing label: nowhere"
 	if err == nil {
 		t.Fatal("expected an error")
 	}
-	if !errors.Is(err, wantErr) {
-		t.Errorf("wanted error %v, got %v", wantErr, err)
+	if want != err.Error() {
+		t.Errorf("want message %q, got %q", want, err.Error())
+	}
+}
+
+func TestAssemble_ReportsInvalidOperandAtTheOperand(t *testing.T) {
+	t.Parallel()
+	_, err := assembleFromString("HALT\nJUMP 'a'")
+	var errs lexer.ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("want a lexer.ErrorList, got %v", err)
+	}
+	want := token.Position{Offset: 10, Line: 2, Column: 6}
+	got := errs[0].Pos
+	if want != got {
+		t.Errorf("want position %+v, got %+v", want, got)
+	}
+}
+
+func TestAssemble_DoesNotReportReferencesToDefinitionsWithSyntaxErrors(t *testing.T) {
+	t.Parallel()
+	_, err := assembleFromString("VARB n 4a\nMOVE n -> A\nHALT")
+	var errs lexer.ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("want a lexer.ErrorList, got %v", err)
+	}
+	if len(errs) != 1 {
+		t.Errorf("want 1 error, got %d:\n%v", len(errs), err)
 	}
 }
 
@@ -826,7 +2066,7 @@ func TestSettingAConstant(t *testing.T) {
 CONS c 1
 SETA c
 `
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	assembleAndRunFromString(g, input)
 	wantA := gmachine.Word(1)
 	gotA := g.A
@@ -858,13 +2098,13 @@ OUTA
 
 func TestVARB_DeclaresAIntegerVariableInMemory(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
-	err := assembleAndRunFromString(g, `VARB num 42`)
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, "HALT\nVARB num 42")
 	if err != nil {
 		t.Fatal("didn't expect an error:", err)
 	}
 	var want gmachine.Word = 42
-	got := g.Memory[g.MemOffset]
+	got := g.Memory[g.MemOffset+1]
 	if want != got {
 		t.Errorf("want num %d, got %d", want, got)
 	}
@@ -872,7 +2112,7 @@ func TestVARB_DeclaresAIntegerVariableInMemory(t *testing.T) {
 
 func TestMOVE_MovesAccumulatorRegisterToVariable(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	err := assembleAndRunFromString(g, `
 JUMP start
 VARB num 0
@@ -894,7 +2134,7 @@ HALT
 
 func TestMOVE_MovesValueOfVariableIntoAccumulatorRegister(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	err := assembleAndRunFromString(g, `
 JUMP start
 VARB num 42
@@ -913,7 +2153,7 @@ HALT
 
 func TestVARB_DeclaresAStringVariableInMemory(t *testing.T) {
 	t.Parallel()
-	g := gmachine.New(nil)
+	g := gmachine.New()
 	err := assembleAndRunFromString(g, `
 JUMP start
 VARB msg "hello world"
@@ -947,17 +2187,151 @@ func TestCompile(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	want := []byte{
-		0, 0, 0, 0, 0, 0, 0, byte(gmachine.OpSETA),
-		0, 0, 0, 0, 0, 0, 0, byte(42),
-		0, 0, 0, 0, 0, 0, 0, byte(gmachine.OpOUTA),
+	want := &gmachine.Executable{
+		HasEntry: true,
+		Code:     []gmachine.Word{gmachine.OpSETA, 42, gmachine.OpOUTA},
+		Data:     []gmachine.Word{},
+	}
+	got, err := gmachine.ReadExecutable(&buf)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestCompile_PutsTrailingVariablesInDataSegment(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	input := "SETA 1\nJUMP end\nVARB n 7\n.end\nHALT\nVARB msg \"hi\""
+	err := gmachine.Compile(strings.NewReader(input), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &gmachine.Executable{
+		HasEntry: true,
+		Code:     []gmachine.Word{gmachine.OpSETA, 1, gmachine.OpJUMP, 5, 7, gmachine.OpHALT},
+		Data:     []gmachine.Word{'h', 'i', 0},
+	}
+	got, err := gmachine.ReadExecutable(&buf)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
 	}
-	got := buf.Bytes()
 	if !cmp.Equal(want, got) {
 		t.Error(cmp.Diff(want, got))
 	}
 }
 
+func TestCompile_RecordsStartLabelAsEntryPoint(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	input := "VARB n 7\n.start\nHALT"
+	err := gmachine.Compile(strings.NewReader(input), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exe, err := gmachine.ReadExecutable(&buf)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var want gmachine.Word = 1
+	if want != exe.Entry {
+		t.Errorf("want entry point %d, got %d", want, exe.Entry)
+	}
+}
+
+func TestAssembleAndRun_StartsAtStartLabel(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, `
+.double
+MULA X
+RTRN
+.start
+SETA 21
+SETX 2
+CALL double
+HALT`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var want gmachine.Word = 42
+	if want != g.A {
+		t.Errorf("want A %d, got %d", want, g.A)
+	}
+}
+
+func TestAssembleAndRun_StartsAtPWithoutStartLabel(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	g.P = 2
+	err := assembleAndRunFromString(g, "SETA 1\nSETA 2\nHALT")
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var want gmachine.Word = 2
+	if want != g.A {
+		t.Errorf("want A %d, got %d", want, g.A)
+	}
+}
+
+func TestAssemble_ReturnsErrorWhenStartLabelsNoInstruction(t *testing.T) {
+	t.Parallel()
+	for _, input := range []string{
+		"HALT\nVARB x 7\n.start",
+		"HALT\n.start\nVARB x 7",
+		".start",
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := assembleFromString(input)
+			wantErr := gmachine.ErrInvalidEntryPoint
+			if !errors.Is(err, wantErr) {
+				t.Fatalf("wanted error %v, got %v", wantErr, err)
+			}
+			want := "must label an instruction"
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("want message containing %q, got %q", want, err)
+			}
+		})
+	}
+}
+
+func TestAssembleAndRun_RunsExamples(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		path string
+		want []byte
+	}{
+		{"examples/add.g", []byte{0, 0, 0, 0, 0, 0, 0, 10}},
+		{"examples/factorial.g", []byte{0, 0, 0, 0, 0, 0, 2, 208}},
+		{"examples/hello.g", []byte("hello world!")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			f, err := os.Open(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			var out bytes.Buffer
+			g := gmachine.New(gmachine.WithOutput(&out))
+			err = g.AssembleAndRun(f)
+			if err != nil {
+				t.Fatal("didn't expect an error:", err)
+			}
+			got := out.Bytes()
+			if !cmp.Equal(tt.want, got) {
+				t.Error(cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
 func TestCompile_FailsForInvalidInput(t *testing.T) {
 	t.Parallel()
 
@@ -984,6 +2358,369 @@ func TestCompile_FailsForWriteError(t *testing.T) {
 	}
 }
 
+func TestAssemble_EvaluatesConstantExpressions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input string
+		want  gmachine.Word
+	}{
+		{"SETA 1+2*3", 7},
+		{"SETA (1+2)*3", 9},
+		{"SETA 10-2-3", 5},
+		{"SETA 2-1", 1},
+		{"SETA 7/2", 3},
+		{"SETA 7%4", 3},
+		{"SETA 1<<4|1", 17},
+		{"SETA 256>>4", 16},
+		{"SETA 0xff&~0xf", 0xf0},
+		{"SETA -(2+3)", 1<<64 - 5},
+		{"SETA 'a'+1", 'b'},
+		{"CONS SIZE 4*8\nSETA SIZE", 32},
+		{"CONS Q P+1\nCONS P 2\nSETA Q", 3},
+		{"SETA len(msg)\nHALT\nVARB msg \"hello\"", 5},
+		{"SETA len(n)\nHALT\nVARB n 42", 1},
+		{"SETA 1\nSUBA SIZE/4\nCONS SIZE 4", 0},
+		{"JUMP $+4\nSETA 1\nHALT", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			g := gmachine.New()
+			err := assembleAndRunFromString(g, tt.input+"\nHALT")
+			if err != nil {
+				t.Fatal("didn't expect an error:", err)
+			}
+			if tt.want != g.A {
+				t.Errorf("want A %d, got %d", tt.want, g.A)
+			}
+		})
+	}
+}
+
+func TestAssemble_EvaluatesOperandsReferringToLaterSymbols(t *testing.T) {
+	t.Parallel()
+	program, err := assembleFromString("SETX msg+3\nHALT\nVARB msg \"abc\"\nVARB end $+1")
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	want := []gmachine.Word{gmachine.OpSETX, 6, gmachine.OpHALT, 'a', 'b', 'c', 0, 8}
+	if !cmp.Equal(want, program) {
+		t.Error(cmp.Diff(want, program))
+	}
+}
+
+func TestAssemble_ReturnsErrorForInvalidConstantExpressions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input   string
+		wantErr error
+		wantPos string
+	}{
+		{"SETA 1/0", gmachine.ErrDivisionByZero, "1:8"},
+		{"CONS ZERO 0\nSETA 1%ZERO", gmachine.ErrDivisionByZero, "2:8"},
+		{"CONS P Q\nCONS Q P", gmachine.ErrCircularDefinition, ""},
+		{"CONS P nowhere", gmachine.ErrUnknownIdentifier, "1:8"},
+		{"SETA A+1", gmachine.ErrInvalidOperand, "1:6"},
+		{"SETA len(start)\n.start\nHALT", gmachine.ErrInvalidOperand, "1:10"},
+		{"SETA size(msg)\nVARB msg 1", gmachine.ErrInvalidOperand, "1:6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := assembleFromString(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("wanted error %v, got %v", tt.wantErr, err)
+			}
+			var errs lexer.ErrorList
+			if !errors.As(err, &errs) {
+				t.Fatalf("want a lexer.ErrorList, got %v", err)
+			}
+			if len(errs) != 1 {
+				t.Fatalf("want 1 error, got %d: %v", len(errs), errs)
+			}
+			if tt.wantPos != "" && tt.wantPos != errs[0].Pos.String() {
+				t.Errorf("want position %s, got %s", tt.wantPos, errs[0].Pos)
+			}
+		})
+	}
+}
+
+func TestAssemble_ExpandsMacrosWithTheirArguments(t *testing.T) {
+	t.Parallel()
+	program, err := assembleFromString(`MACRO store value, name
+SETA value
+MOVE A -> name
+ENDM
+store 1+2, x
+store 'c'*2, y
+HALT
+VARB x 0
+VARB y 0`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	want := []gmachine.Word{
+		gmachine.OpSETA, 3, gmachine.OpMVAV, 9,
+		gmachine.OpSETA, 'c' * 2, gmachine.OpMVAV, 10,
+		gmachine.OpHALT, 0, 0,
+	}
+	if !cmp.Equal(want, program) {
+		t.Error(cmp.Diff(want, program))
+	}
+}
+
+func TestAssemble_GivesEachMacroExpansionItsOwnLabels(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, `countdown 3
+countdown 2
+HALT
+MACRO countdown n
+SETX n
+.loop
+INCA
+DECX
+JXNZ loop
+ENDM`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var want gmachine.Word = 5
+	if want != g.A {
+		t.Errorf("want A %d, got %d", want, g.A)
+	}
+}
+
+func TestAssemble_GivesEachMacroExpansionItsOwnConstantsAndVariables(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, `add 3
+add 4
+HALT
+MACRO add n
+CONS k n
+JUMP skip
+VARB v k
+.skip
+MOVE v -> A
+ADDA Y
+MOVE A -> Y
+ENDM`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var want gmachine.Word = 7
+	if want != g.Y {
+		t.Errorf("want Y %d, got %d", want, g.Y)
+	}
+}
+
+func TestAssemble_ExpandsMacrosCalledFromOtherMacros(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := assembleAndRunFromString(g, `MACRO twice x
+add x
+add x
+ENDM
+MACRO add x
+ADDA x
+ENDM
+SETX 2
+SETA 1
+twice X
+HALT`)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var want gmachine.Word = 5
+	if want != g.A {
+		t.Errorf("want A %d, got %d", want, g.A)
+	}
+}
+
+func TestAssemble_ReportsErrorsInMacrosAtTheCallAndInTheBody(t *testing.T) {
+	t.Parallel()
+	_, err := gmachine.Assemble(strings.NewReader(`MACRO bad
+SETA A
+JUMP nowhere
+ENDM
+MACRO worse
+bad
+ENDM
+HALT
+bad
+worse`), gmachine.WithFilename("test.g"))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	want := `test.g:9:1: in macro bad: test.g:2:6: invalid operand: SETA
+test.g:9:1: in macro bad: test.g:3:6: missing label: nowhere
+test.g:10:1: in macro worse: test.g:6:1: in macro bad: test.g:2:6: invalid operand: SETA
+test.g:10:1: in macro worse: test.g:6:1: in macro bad: test.g:3:6: missing label: nowhere`
+	if want != err.Error() {
+		t.Errorf("want message:\n%s\ngot:\n%s", want, err)
+	}
+}
+
+func TestAssemble_ReturnsErrorForInvalidMacroCalls(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input   string
+		wantErr error
+	}{
+		{"nothing", gmachine.ErrUndefinedInstruction},
+		{"MACRO one x\nSETA x\nENDM\none", gmachine.ErrMacroArguments},
+		{"MACRO one x\nSETA x\nENDM\none 1, 2", gmachine.ErrMacroArguments},
+		{"MACRO loop\nloop\nENDM\nloop", gmachine.ErrRecursiveMacro},
+		{"MACRO ping\npong\nENDM\nMACRO pong\nping\nENDM\nping", gmachine.ErrRecursiveMacro},
+		{"MACRO twice\nENDM\nMACRO twice\nENDM", gmachine.ErrMacroRedefined},
+		{"MACRO open\nHALT", parser.ErrInvalidSyntax},
+		{"ENDM", parser.ErrInvalidSyntax},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := assembleFromString(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("wanted error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// writeFiles writes each of files, keyed by name, to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAssemble_IncludesFilesRelativeToTheIncludingFile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"lib/math.g":   "INCLUDE \"consts.g\"\nMACRO double\nSHLA ONE\nENDM",
+		"lib/consts.g": "CONS ONE 1",
+		"shared/inc.g": ".inc\nINCA\nRTRN",
+	})
+	main := filepath.Join(dir, "main.g")
+	input := "INCLUDE \"lib/math.g\"\nINCLUDE \"inc.g\"\n.start\nSETA 3\ndouble\nCALL inc\nHALT"
+	g := gmachine.New()
+	err := g.AssembleAndRun(strings.NewReader(input),
+		gmachine.WithFilename(main),
+		gmachine.WithSearchPath(filepath.Join(dir, "shared")),
+	)
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	var want gmachine.Word = 7
+	if want != g.A {
+		t.Errorf("want A %d, got %d", want, g.A)
+	}
+}
+
+func TestAssemble_ReportsErrorsInIncludedFilesWithTheirFilename(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"lib.g": "HALT\nSETA nowhere\nSETX 2a",
+	})
+	main := filepath.Join(dir, "main.g")
+	_, err := gmachine.Assemble(strings.NewReader("INCLUDE \"lib.g\"\nJUMP gone"), gmachine.WithFilename(main))
+	var errs lexer.ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("want a lexer.ErrorList, got %v", err)
+	}
+	lib := filepath.Join(dir, "lib.g")
+	want := []string{lib + ":2:6", lib + ":3:6", main + ":2:6"}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Pos.String())
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestAssemble_ReturnsErrorForInvalidIncludes(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.g":  "INCLUDE \"main.g\"",
+		"self.g":  "INCLUDE \"self.g\"",
+		"a.g":     "INCLUDE \"sub/b.g\"",
+		"sub/b.g": "INCLUDE \"../a.g\"",
+	})
+	tests := []struct {
+		input   string
+		wantErr error
+	}{
+		{"INCLUDE \"missing.g\"", gmachine.ErrIncludeNotFound},
+		{"INCLUDE \"self.g\"", gmachine.ErrIncludeCycle},
+		{"INCLUDE \"a.g\"", gmachine.ErrIncludeCycle},
+		{"INCLUDE \"main.g\"", gmachine.ErrIncludeCycle},
+		{"INCLUDE missing", parser.ErrInvalidSyntax},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := gmachine.Assemble(strings.NewReader(tt.input), gmachine.WithFilename(filepath.Join(dir, "main.g")))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("wanted error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAssemble_ReturnsErrorForSymbolsDefinedTwice(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input   string
+		wantMsg string
+	}{
+		{".loop\nHALT\n.loop", "3:1: symbol already defined: loop, first defined at 1:1"},
+		{"CONS n 1\nVARB n 2", "2:6: symbol already defined: n, first defined at 1:6"},
+		{"VARB n 1\n.n", "2:1: symbol already defined: n, first defined at 1:6"},
+		{"MACRO var name\nVARB name 0\nENDM\nvar v\nvar v", "5:1: in macro var: 5:5: symbol already defined: v, first defined at 4:5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := assembleFromString(tt.input)
+			if !errors.Is(err, gmachine.ErrSymbolRedefined) {
+				t.Fatalf("wanted error %v, got %v", gmachine.ErrSymbolRedefined, err)
+			}
+			if tt.wantMsg != err.Error() {
+				t.Errorf("want message %q, got %q", tt.wantMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestAssemble_IncludesEachFileOnce(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.g":      "INCLUDE \"common.g\"",
+		"b.g":      "INCLUDE \"common.g\"",
+		"common.g": "MACRO inc\nINCA\nENDM\n.lib\nRTRN",
+	})
+	main := filepath.Join(dir, "main.g")
+	input := "INCLUDE \"a.g\"\nINCLUDE \"b.g\"\n.start\ninc\nHALT"
+	program, err := gmachine.Assemble(strings.NewReader(input), gmachine.WithFilename(main))
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	want := []gmachine.Word{gmachine.OpRTRN, gmachine.OpINCA, gmachine.OpHALT}
+	if !cmp.Equal(want, program) {
+		t.Error(cmp.Diff(want, program))
+	}
+}
+
 func assembleFromString(input string) ([]gmachine.Word, error) {
 	program, err := gmachine.Assemble(strings.NewReader(input))
 	return program, err
@@ -0,0 +1,141 @@
+package gmachine
+
+import (
+	"errors"
+	"fmt"
+	"gmachine/ast"
+	"gmachine/lexer"
+	"gmachine/parser"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var ErrIncludeNotFound error = errors.New("included file not found")
+var ErrIncludeCycle error = errors.New("include cycle")
+
+// sourceLine identifies a line of one of the files a program is assembled
+// from.
+type sourceLine struct {
+	filename string
+	line     int
+}
+
+type includer struct {
+	searchPath []string
+	// files holds the names of the files being included, outermost first,
+	// and paths their absolute paths, to catch files that include
+	// themselves.
+	files []string
+	paths []string
+	// included holds the absolute paths of the files included so far.
+	included map[string]bool
+	errs     lexer.ErrorList
+}
+
+// includeFiles returns stmts, read from the named file, with each INCLUDE
+// statement replaced by the statements of the file it names. The file is
+// looked for relative to the directory of the file that includes it, and
+// then in each directory of searchPath in turn. Each file is only included
+// once, so that libraries can include the files they depend on without
+// clashing when they're used together.
+func includeFiles(stmts []ast.Statement, filename string, searchPath []string) ([]ast.Statement, lexer.ErrorList) {
+	in := &includer{searchPath: searchPath, included: make(map[string]bool)}
+	if filename != "" {
+		in.push(filename)
+	}
+	return in.include(stmts, filename), in.errs
+}
+
+func (in *includer) include(stmts []ast.Statement, filename string) []ast.Statement {
+	var included []ast.Statement
+	for _, stmt := range stmts {
+		inc, ok := stmt.(ast.IncludeStatement)
+		if !ok {
+			included = append(included, stmt)
+			continue
+		}
+		if inc.Path.Token.Type == "" {
+			// The path is missing, and the syntax error has already been
+			// reported.
+			continue
+		}
+		included = append(included, in.file(inc, filename)...)
+	}
+	return included
+}
+
+// file returns the statements of the file named by inc, which appears in
+// the file from.
+func (in *includer) file(inc ast.IncludeStatement, from string) []ast.Statement {
+	name, ok := in.resolve(inc.Path.Value, from)
+	if !ok {
+		in.errs.Add(inc.Path.Pos(), fmt.Errorf("%w: %s", ErrIncludeNotFound, inc.Path.Value))
+		return nil
+	}
+	if i := slices.Index(in.paths, absPath(name)); i >= 0 {
+		cycle := strings.Join(append(slices.Clone(in.files[i:]), name), " includes ")
+		in.errs.Add(inc.Path.Pos(), fmt.Errorf("%w: %s", ErrIncludeCycle, cycle))
+		return nil
+	}
+	if in.included[absPath(name)] {
+		return nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		in.errs.Add(inc.Path.Pos(), err)
+		return nil
+	}
+	defer f.Close()
+	l, err := lexer.NewFile(name, f)
+	if err != nil {
+		in.errs.Add(inc.Path.Pos(), err)
+		return nil
+	}
+	p := parser.New(l)
+	program := p.ParseProgram()
+	in.errs = append(in.errs, p.Errors()...)
+
+	in.push(name)
+	defer in.pop()
+	return in.include(program.Statements, name)
+}
+
+// resolve returns the name of the file at path, relative to the directory of
+// the file from or else to a directory of the search path, and whether
+// there is one.
+func (in *includer) resolve(path, from string) (string, bool) {
+	if filepath.IsAbs(path) {
+		_, err := os.Stat(path)
+		return path, err == nil
+	}
+	for _, dir := range append([]string{filepath.Dir(from)}, in.searchPath...) {
+		name := filepath.Join(dir, path)
+		if _, err := os.Stat(name); err == nil {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (in *includer) push(name string) {
+	in.files = append(in.files, name)
+	in.paths = append(in.paths, absPath(name))
+	in.included[absPath(name)] = true
+}
+
+func (in *includer) pop() {
+	in.files = in.files[:len(in.files)-1]
+	in.paths = in.paths[:len(in.paths)-1]
+}
+
+// absPath returns the absolute form of name, or name itself if there isn't
+// one.
+func absPath(name string) string {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+	return abs
+}
@@ -0,0 +1,159 @@
+package gmachine
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// OperandKind describes how the word following an opcode is interpreted.
+type OperandKind int
+
+const (
+	OperandNone OperandKind = iota
+	OperandRegister
+	OperandImmediate
+	OperandAddress
+	OperandVariable
+)
+
+var operandKinds = map[Word]OperandKind{
+	OpADDA:  OperandRegister,
+	OpMULA:  OperandRegister,
+	OpCMPA:  OperandRegister,
+	OpSUBA:  OperandRegister,
+	OpDIVA:  OperandRegister,
+	OpMODA:  OperandRegister,
+	OpANDA:  OperandRegister,
+	OpORA:   OperandRegister,
+	OpXORA:  OperandRegister,
+	OpSHLA:  OperandRegister,
+	OpSHRA:  OperandRegister,
+	OpROLA:  OperandRegister,
+	OpRORA:  OperandRegister,
+	OpSETA:  OperandImmediate,
+	OpSETX:  OperandImmediate,
+	OpSETY:  OperandImmediate,
+	OpCMPAI: OperandImmediate,
+	OpSUBAI: OperandImmediate,
+	OpDIVAI: OperandImmediate,
+	OpMODAI: OperandImmediate,
+	OpANDAI: OperandImmediate,
+	OpORAI:  OperandImmediate,
+	OpXORAI: OperandImmediate,
+	OpSHLAI: OperandImmediate,
+	OpSHRAI: OperandImmediate,
+	OpROLAI: OperandImmediate,
+	OpRORAI: OperandImmediate,
+	OpJUMP:  OperandAddress,
+	OpJXNZ:  OperandAddress,
+	OpCALL:  OperandAddress,
+	OpJZ:    OperandAddress,
+	OpJNZ:   OperandAddress,
+	OpJEQ:   OperandAddress,
+	OpJLT:   OperandAddress,
+	OpJGT:   OperandAddress,
+	OpJSLT:  OperandAddress,
+	OpJSGT:  OperandAddress,
+	OpJANZ:  OperandAddress,
+	OpJAZ:   OperandAddress,
+	OpJYNZ:  OperandAddress,
+	OpMVAV:  OperandVariable,
+	OpMVVA:  OperandVariable,
+}
+
+var mnemonics = func() map[Word]string {
+	m := make(map[Word]string, len(opcodes))
+	for name, opcode := range opcodes {
+		m[opcode] = name
+	}
+	return m
+}()
+
+// mnemonic returns the name of opcode in the opcodes table.
+func mnemonic(opcode Word) string {
+	if name, ok := mnemonics[opcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("%#x", opcode)
+}
+
+// Instruction is a single decoded G-machine instruction.
+type Instruction struct {
+	Opcode  Word
+	Operand Word
+	Kind    OperandKind
+}
+
+// Size returns the number of words the instruction occupies.
+func (i Instruction) Size() Word {
+	if i.Kind == OperandNone {
+		return 1
+	}
+	return 2
+}
+
+// Mnemonic returns the name of the instruction's opcode, such as "CMPAI" or
+// "MVIAX".
+func (i Instruction) Mnemonic() string {
+	return mnemonic(i.Opcode)
+}
+
+// String returns the instruction in assembler syntax, with addresses and
+// immediate values written as numbers.
+func (i Instruction) String() string {
+	name := i.Mnemonic()
+	switch i.Opcode {
+	case OpMVAX:
+		return "MOVE A -> X"
+	case OpMVIAX:
+		return "MOVE *A -> X"
+	case OpMVAY:
+		return "MOVE A -> Y"
+	case OpMVAV:
+		return fmt.Sprintf("MOVE A -> %d", i.Operand)
+	case OpMVVA:
+		return fmt.Sprintf("MOVE %d -> A", i.Operand)
+	}
+	switch i.Kind {
+	case OperandRegister:
+		return fmt.Sprintf("%s %s", name, registerName(i.Operand))
+	case OperandImmediate:
+		// Immediate forms of register instructions share their mnemonic.
+		return fmt.Sprintf("%s %d", strings.TrimSuffix(name, "I"), i.Operand)
+	case OperandAddress:
+		return fmt.Sprintf("%s %d", name, i.Operand)
+	default:
+		return name
+	}
+}
+
+// Decode decodes the instruction at addr in program. It returns
+// ErrUnknownOpcode if the word at addr is not an opcode, and
+// io.ErrUnexpectedEOF if its operand lies beyond the end of program.
+func Decode(program []Word, addr Word) (Instruction, error) {
+	if addr >= Word(len(program)) {
+		return Instruction{}, io.ErrUnexpectedEOF
+	}
+	opcode := program[addr]
+	if _, ok := mnemonics[opcode]; !ok {
+		return Instruction{Opcode: opcode}, fmt.Errorf("%w: %#x at address %d", ErrUnknownOpcode, opcode, addr)
+	}
+	inst := Instruction{Opcode: opcode, Kind: operandKinds[opcode]}
+	if inst.Kind != OperandNone {
+		if addr+1 >= Word(len(program)) {
+			return inst, io.ErrUnexpectedEOF
+		}
+		inst.Operand = program[addr+1]
+	}
+	return inst, nil
+}
+
+func registerName(r Word) string {
+	for name, reg := range registers {
+		if reg == r {
+			return name
+		}
+	}
+	return fmt.Sprintf("%d", r)
+}
@@ -0,0 +1,62 @@
+package gmachine_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"gmachine"
+)
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		program  []gmachine.Word
+		want     string
+		wantSize gmachine.Word
+	}{
+		{[]gmachine.Word{gmachine.OpHALT}, "HALT", 1},
+		{[]gmachine.Word{gmachine.OpSETA, 42}, "SETA 42", 2},
+		{[]gmachine.Word{gmachine.OpADDA, gmachine.RegY}, "ADDA Y", 2},
+		{[]gmachine.Word{gmachine.OpCMPAI, 5}, "CMPA 5", 2},
+		{[]gmachine.Word{gmachine.OpSHRAI, 3}, "SHRA 3", 2},
+		{[]gmachine.Word{gmachine.OpJXNZ, 7}, "JXNZ 7", 2},
+		{[]gmachine.Word{gmachine.OpMVAX}, "MOVE A -> X", 1},
+		{[]gmachine.Word{gmachine.OpMVIAX}, "MOVE *A -> X", 1},
+		{[]gmachine.Word{gmachine.OpMVAV, 9}, "MOVE A -> 9", 2},
+		{[]gmachine.Word{gmachine.OpMVVA, 9}, "MOVE 9 -> A", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			inst, err := gmachine.Decode(tt.program, 0)
+			if err != nil {
+				t.Fatal("didn't expect an error:", err)
+			}
+			got := inst.String()
+			if tt.want != got {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+			if tt.wantSize != inst.Size() {
+				t.Errorf("want size %d, got %d", tt.wantSize, inst.Size())
+			}
+		})
+	}
+}
+
+func TestDecode_ReturnsErrorForUnknownOpcode(t *testing.T) {
+	t.Parallel()
+	_, err := gmachine.Decode([]gmachine.Word{0xff}, 0)
+	wantErr := gmachine.ErrUnknownOpcode
+	if !errors.Is(err, wantErr) {
+		t.Errorf("wanted error %v, got %v", wantErr, err)
+	}
+}
+
+func TestDecode_ReturnsErrorForMissingOperand(t *testing.T) {
+	t.Parallel()
+	_, err := gmachine.Decode([]gmachine.Word{gmachine.OpHALT, gmachine.OpSETA}, 1)
+	wantErr := io.ErrUnexpectedEOF
+	if !errors.Is(err, wantErr) {
+		t.Errorf("wanted error %v, got %v", wantErr, err)
+	}
+}
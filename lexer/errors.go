@@ -0,0 +1,71 @@
+package lexer
+
+import (
+	"fmt"
+	"gmachine/token"
+	"slices"
+	"strings"
+)
+
+// Error is a problem found at a position in the source of a program.
+type Error struct {
+	Pos token.Position
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Pos, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorList is a list of errors found in the source of a program. It
+// matches, with errors.Is and errors.As, any error one of its elements does.
+type ErrorList []*Error
+
+// Add appends an error at pos to the list.
+func (l *ErrorList) Add(pos token.Position, err error) {
+	*l = append(*l, &Error{Pos: pos, Err: err})
+}
+
+// Sort sorts the list by filename and then position, keeping errors at the
+// same position in the order they were added.
+func (l ErrorList) Sort() {
+	slices.SortStableFunc(l, func(a, b *Error) int {
+		switch {
+		case a.Pos.Filename != b.Pos.Filename:
+			return strings.Compare(a.Pos.Filename, b.Pos.Filename)
+		case a.Pos.Line != b.Pos.Line:
+			return a.Pos.Line - b.Pos.Line
+		default:
+			return a.Pos.Column - b.Pos.Column
+		}
+	})
+}
+
+// Err returns the list as an error, or nil if it is empty.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// Error returns every error in the list, one per line.
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, err := range l {
+		errs[i] = err
+	}
+	return errs
+}
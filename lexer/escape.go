@@ -0,0 +1,81 @@
+package lexer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var escapes = map[byte]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+	'\'': '\'',
+}
+
+// Unescape returns s, the text of a character or string literal without its
+// quotes, with each escape sequence replaced by the character it stands for.
+// As well as the single-character escapes \n, \t, \r, \0, \\, \" and \', it
+// accepts \xNN, two hex digits, and \u{N...}, from one to six hex digits
+// naming a Unicode code point.
+func Unescape(s string) (string, error) {
+	if !strings.ContainsRune(s, '\\') {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '\\' {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			b.WriteRune(r)
+			i += size
+			continue
+		}
+		r, size, err := unescape(s[i:])
+		if err != nil {
+			return "", err
+		}
+		b.WriteRune(r)
+		i += size
+	}
+	return b.String(), nil
+}
+
+// unescape decodes the escape sequence at the start of s, returning the
+// character it stands for and its length.
+func unescape(s string) (rune, int, error) {
+	if len(s) < 2 {
+		return 0, 0, fmt.Errorf("%w: \\ at end of literal", ErrInvalidEscape)
+	}
+	if r, ok := escapes[s[1]]; ok {
+		return r, 2, nil
+	}
+	switch s[1] {
+	case 'x':
+		if len(s) < 4 {
+			return 0, 0, fmt.Errorf("%w: %s needs two hex digits", ErrInvalidEscape, s)
+		}
+		v, err := strconv.ParseUint(s[2:4], 16, 8)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: %s needs two hex digits", ErrInvalidEscape, s[:4])
+		}
+		return rune(v), 4, nil
+	case 'u':
+		end := strings.IndexByte(s, '}')
+		if !strings.HasPrefix(s[2:], "{") || end < 0 {
+			return 0, 0, fmt.Errorf("%w: \\u must be followed by {hex digits}", ErrInvalidEscape)
+		}
+		digits := s[3:end]
+		v, err := strconv.ParseUint(digits, 16, 32)
+		if err != nil || len(digits) > 6 || !utf8.ValidRune(rune(v)) {
+			return 0, 0, fmt.Errorf("%w: %s is not a valid code point", ErrInvalidEscape, s[:end+1])
+		}
+		return rune(v), end + 1, nil
+	default:
+		r, _ := utf8.DecodeRuneInString(s[1:])
+		return 0, 0, fmt.Errorf("%w: \\%c", ErrInvalidEscape, r)
+	}
+}
@@ -2,28 +2,60 @@ package lexer
 
 import (
 	"errors"
+	"fmt"
 	"gmachine/token"
 	"io"
 	"unicode"
+	"unicode/utf8"
 )
 
 var ErrInvalidNumberLiteral error = errors.New("invalid number")
-var ErrInvalidCharacterLiteral error = errors.New("invalid character literal, missing closing '")
+var ErrInvalidCharacterLiteral error = errors.New("invalid character literal")
+var ErrInvalidStringLiteral error = errors.New("invalid string literal")
+var ErrInvalidEscape error = errors.New("invalid escape sequence")
+var ErrIllegalCharacter error = errors.New("illegal character")
+
+// operators maps the characters that make up a single-character operator or
+// delimiter to the type of token they form.
+var operators = map[rune]token.TokenType{
+	'+': token.PLUS,
+	'/': token.SLASH,
+	'%': token.PERCENT,
+	'&': token.AMPERSAND,
+	'|': token.PIPE,
+	'~': token.TILDE,
+	'(': token.LPAREN,
+	')': token.RPAREN,
+	'$': token.DOLLAR,
+	',': token.COMMA,
+}
 
 type Lexer struct {
+	filename      string
 	input         []rune
-	line          int  // current line number in input (for current rune)
-	position      int  // current position in input (points to current rune)
-	nextRuneIndex int  // current reading position in input (after current rune)
-	currentRune   rune // current rune under examination
+	line          int            // current line number in input (for current rune)
+	lineStart     int            // position in input of the first rune of the current line
+	position      int            // current position in input (points to current rune)
+	nextRuneIndex int            // current reading position in input (after current rune)
+	offset        int            // byte offset in the original input of the current rune
+	currentRune   rune           // current rune under examination
+	tokenPos      token.Position // position of the first rune of the current token
+	prev          token.Token    // the token most recently returned
+	errors        ErrorList
 }
 
 func New(reader io.Reader) (*Lexer, error) {
+	return NewFile("", reader)
+}
+
+// NewFile returns a lexer for the contents of the named file, read from
+// reader. The position of each token includes the filename.
+func NewFile(filename string, reader io.Reader) (*Lexer, error) {
 	input, err := io.ReadAll(reader)
 	if err != nil {
 		return nil, err
 	}
-	l := &Lexer{input: []rune(string(input)), line: 1}
+	l := &Lexer{filename: filename, input: []rune(string(input)), line: 1}
 	l.readRune()
 	return l, nil
 }
@@ -31,16 +63,15 @@ func New(reader io.Reader) (*Lexer, error) {
 func (l *Lexer) NextToken() token.Token {
 	for {
 		l.skipWhitespace()
+		l.tokenPos = l.pos()
 		switch {
 		case l.currentRune == ';':
 			l.readUntil('\n')
 			continue
 		case l.currentRune == '\'':
-			char := l.readCharacter()
-			return l.newToken(token.CHAR, char)
+			return l.readCharacter()
 		case l.currentRune == '"':
-			str := l.readString()
-			return l.newToken(token.STRING, str)
+			return l.readString()
 		case l.currentRune == '*':
 			l.readRune()
 			return l.newToken(token.ASTERISK, "*")
@@ -50,12 +81,30 @@ func (l *Lexer) NextToken() token.Token {
 				l.readRune()
 				return l.newToken(token.ARROW, "->")
 			}
-			return l.newToken(token.ILLEGAL, string(l.currentRune))
+			if unicode.IsDigit(l.peekRune()) && !l.afterOperand() {
+				return l.newToken(token.INT, l.readNumber())
+			}
+			l.readRune()
+			return l.newToken(token.MINUS, "-")
+		case l.currentRune == '<' || l.currentRune == '>':
+			if l.peekRune() != l.currentRune {
+				return l.illegal()
+			}
+			literal := string([]rune{l.currentRune, l.currentRune})
+			l.readRune()
+			l.readRune()
+			if literal == "<<" {
+				return l.newToken(token.SHL, literal)
+			}
+			return l.newToken(token.SHR, literal)
+		case operators[l.currentRune] != "":
+			kind := operators[l.currentRune]
+			l.readRune()
+			return l.newToken(kind, string(kind))
 		case l.currentRune == 0:
 			return l.newToken(token.EOF, "")
 		case unicode.IsDigit(l.currentRune):
-			value := l.readUntil('\n')
-			return l.newToken(token.INT, value)
+			return l.newToken(token.INT, l.readNumber())
 		case l.currentRune == '.':
 			literal := l.readIdentifier()
 			return l.newToken(token.LABEL_DEFINITION, literal)
@@ -64,17 +113,66 @@ func (l *Lexer) NextToken() token.Token {
 			kind := token.LookupIdent(literal)
 			return l.newToken(kind, literal)
 		default:
-			// Should we continue lexing if there is an illegal token?
-			return l.newToken(token.ILLEGAL, string(l.currentRune))
+			return l.illegal()
 		}
 	}
 }
 
+// Errors returns the problems found in the input so far.
+func (l *Lexer) Errors() ErrorList {
+	return l.errors
+}
+
+// illegal records an error for the current rune, and returns it as an
+// ILLEGAL token so that lexing can continue after it.
+func (l *Lexer) illegal() token.Token {
+	literal := string(l.currentRune)
+	l.readRune()
+	return l.invalid(literal, fmt.Errorf("%w: %q", ErrIllegalCharacter, literal))
+}
+
+// invalid records err for the current token, and returns it as an ILLEGAL
+// token with the given literal.
+func (l *Lexer) invalid(literal string, err error) token.Token {
+	l.errors.Add(l.tokenPos, err)
+	return l.newToken(token.ILLEGAL, literal)
+}
+
 func (l *Lexer) newToken(kind token.TokenType, literal string) token.Token {
-	return token.Token{
-		Type:    kind,
-		Literal: literal,
-		Line:    l.line,
+	l.prev = token.Token{
+		Type:     kind,
+		Literal:  literal,
+		Filename: l.filename,
+		Line:     l.tokenPos.Line,
+		Column:   l.tokenPos.Column,
+		Offset:   l.tokenPos.Offset,
+		End:      l.pos(),
+	}
+	return l.prev
+}
+
+// afterOperand reports whether the previous token on the current line ends
+// an operand, so that a minus sign following it is a subtraction rather
+// than the sign of a negative number: 2-1 is 2 minus 1, but SETA -1 sets A
+// to -1.
+func (l *Lexer) afterOperand() bool {
+	if l.prev.Line != l.line {
+		return false
+	}
+	switch l.prev.Type {
+	case token.INT, token.CHAR, token.IDENT, token.RPAREN, token.DOLLAR:
+		return true
+	}
+	return false
+}
+
+// pos returns the position of the current rune.
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.offset,
+		Line:     l.line,
+		Column:   l.position - l.lineStart + 1,
 	}
 }
 
@@ -86,25 +184,74 @@ func (l *Lexer) readUntil(r rune) string {
 	return string(l.input[start:l.position])
 }
 
-func (l *Lexer) readString() string {
-	position := l.position + 1
-	for {
+// readNumber reads an integer literal, with an optional leading minus sign.
+// Letters are included so that a prefix such as 0x, hex digits and
+// malformed literals like 2a are read whole, for the parser to validate.
+func (l *Lexer) readNumber() string {
+	start := l.position
+	if l.currentRune == '-' {
 		l.readRune()
-		if l.currentRune == '"' || l.currentRune == 0 {
-			break
-		}
 	}
-	// consume closing quote
-	l.readRune()
-	return string(l.input[position : l.position-1])
+	for unicode.IsLetter(l.currentRune) || unicode.IsDigit(l.currentRune) || l.currentRune == '_' {
+		l.readRune()
+	}
+	return string(l.input[start:l.position])
 }
 
-func (l *Lexer) readCharacter() string {
+// readString reads a string literal. The token's literal is the text
+// between the quotes, with any escape sequences left in place.
+func (l *Lexer) readString() token.Token {
 	start := l.position
+	content, ok := l.readQuoted('"')
+	if !ok {
+		return l.invalid(string(l.input[start:l.position]), fmt.Errorf("%w: missing closing \"", ErrInvalidStringLiteral))
+	}
+	_, err := Unescape(content)
+	if err != nil {
+		return l.invalid(content, err)
+	}
+	return l.newToken(token.STRING, content)
+}
+
+// readCharacter reads a character literal. The token's literal includes the
+// quotes, with any escape sequence left in place.
+func (l *Lexer) readCharacter() token.Token {
+	start := l.position
+	content, ok := l.readQuoted('\'')
+	literal := string(l.input[start:l.position])
+	if !ok {
+		return l.invalid(literal, fmt.Errorf("%w: missing closing '", ErrInvalidCharacterLiteral))
+	}
+	value, err := Unescape(content)
+	if err != nil {
+		return l.invalid(literal, err)
+	}
+	if utf8.RuneCountInString(value) != 1 {
+		return l.invalid(literal, fmt.Errorf("%w: %s must contain exactly one character", ErrInvalidCharacterLiteral, literal))
+	}
+	return l.newToken(token.CHAR, literal)
+}
+
+// readQuoted reads a literal that starts at the current rune and ends with
+// the next unescaped quote, and returns the text between them. A literal
+// that reaches the end of the line or input is unterminated: it is read up
+// to that point, and ok is false.
+func (l *Lexer) readQuoted(quote rune) (content string, ok bool) {
 	l.readRune()
+	start := l.position
+	for l.currentRune != quote {
+		if l.currentRune == '\\' {
+			l.readRune()
+		}
+		if l.currentRune == '\n' || l.currentRune == 0 {
+			return string(l.input[start:l.position]), false
+		}
+		l.readRune()
+	}
+	content = string(l.input[start:l.position])
+	// consume closing quote
 	l.readRune()
-	l.readRune()
-	return string(l.input[start:l.position])
+	return content, true
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -120,14 +267,19 @@ func (l *Lexer) readIdentifier() string {
 
 func (l *Lexer) skipWhitespace() {
 	for unicode.IsSpace(l.currentRune) {
-		if l.currentRune == '\n' {
+		newline := l.currentRune == '\n'
+		l.readRune()
+		if newline {
 			l.line++
+			l.lineStart = l.position
 		}
-		l.readRune()
 	}
 }
 
 func (l *Lexer) readRune() {
+	if l.position < l.nextRuneIndex && l.position < len(l.input) {
+		l.offset += utf8.RuneLen(l.input[l.position])
+	}
 	l.currentRune = l.peekRune()
 	l.position = l.nextRuneIndex
 	l.nextRuneIndex = l.position + 1
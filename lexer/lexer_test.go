@@ -1,16 +1,19 @@
 package lexer_test
 
 import (
+	"errors"
 	"gmachine/lexer"
 	"gmachine/token"
 	"strings"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestNextToken_ReturnsIllegalTokenForUnknownToken(t *testing.T) {
 	t.Parallel()
-	l := newLexerFromString("~")
-	wantLiteral := "~"
+	l := newLexerFromString("@")
+	wantLiteral := "@"
 	var wantType token.TokenType = token.ILLEGAL
 	got := l.NextToken()
 	if wantLiteral != got.Literal {
@@ -114,3 +117,274 @@ func newLexerFromString(input string) *lexer.Lexer {
 	}
 	return l
 }
+
+func TestNextToken_RecordsColumnOfEachToken(t *testing.T) {
+	t.Parallel()
+	l := newLexerFromString("SETA 42\n  MOVE *A -> X")
+	want := []int{1, 6, 3, 8, 9, 11, 14}
+	for _, col := range want {
+		got := l.NextToken()
+		if col != got.Column {
+			t.Errorf("column of %q wrong - want=%d, got=%d", got.Literal, col, got.Column)
+		}
+	}
+}
+
+func TestNextToken_RecordsErrorForIllegalCharacterAndContinues(t *testing.T) {
+	t.Parallel()
+	l := newLexerFromString("SETA @ 1\n@")
+	var got []token.TokenType
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		got = append(got, tok.Type)
+	}
+	want := []token.TokenType{token.INSTRUCTION, token.ILLEGAL, token.INT, token.ILLEGAL}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	errs := l.Errors()
+	if len(errs) != 2 {
+		t.Fatalf("want 2 errors, got %d: %v", len(errs), errs)
+	}
+	wantPos := token.Position{Offset: 9, Line: 2, Column: 1}
+	if wantPos != errs[1].Pos {
+		t.Errorf("want position %+v, got %+v", wantPos, errs[1].Pos)
+	}
+	wantErr := lexer.ErrIllegalCharacter
+	if !errors.Is(errs[1], wantErr) {
+		t.Errorf("wanted error %v, got %v", wantErr, errs[1])
+	}
+}
+
+func TestErrorList_MatchesEachOfItsErrors(t *testing.T) {
+	t.Parallel()
+	var errs lexer.ErrorList
+	errs.Add(token.Position{Line: 3, Column: 1}, lexer.ErrInvalidNumberLiteral)
+	errs.Add(token.Position{Line: 1, Column: 5}, lexer.ErrIllegalCharacter)
+	errs.Sort()
+	err := errs.Err()
+	for _, want := range []error{lexer.ErrIllegalCharacter, lexer.ErrInvalidNumberLiteral} {
+		if !errors.Is(err, want) {
+			t.Errorf("wanted error %v, got %v", want, err)
+		}
+	}
+	var first *lexer.Error
+	if !errors.As(err, &first) {
+		t.Fatalf("want %v to contain a *lexer.Error", err)
+	}
+	wantMsg := "1:5: illegal character\n3:1: invalid number"
+	if wantMsg != err.Error() {
+		t.Errorf("want message %q, got %q", wantMsg, err.Error())
+	}
+}
+
+func TestErrorList_ErrIsNilWhenEmpty(t *testing.T) {
+	t.Parallel()
+	var errs lexer.ErrorList
+	if err := errs.Err(); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+}
+
+func TestNextToken_RecordsByteOffsetAndEndOfEachToken(t *testing.T) {
+	t.Parallel()
+	l := newLexerFromString("VARB s \"héllo\"\nHALT")
+	tests := []struct {
+		offset int
+		end    token.Position
+	}{
+		{0, token.Position{Offset: 4, Line: 1, Column: 5}},
+		{5, token.Position{Offset: 6, Line: 1, Column: 7}},
+		{7, token.Position{Offset: 15, Line: 1, Column: 15}},
+		{16, token.Position{Offset: 20, Line: 2, Column: 5}},
+	}
+	for _, want := range tests {
+		got := l.NextToken()
+		if want.offset != got.Offset {
+			t.Errorf("offset of %q wrong - want=%d, got=%d", got.Literal, want.offset, got.Offset)
+		}
+		if want.end != got.End {
+			t.Errorf("end of %q wrong - want=%+v, got=%+v", got.Literal, want.end, got.End)
+		}
+	}
+}
+
+func TestNextToken_ReadsIntegerLiteralsUpToTheNextSeparator(t *testing.T) {
+	t.Parallel()
+	l := newLexerFromString("SETA 5 ; note\nSETA -1\nSETA 0xFF;x\nSETA 1_000\nMOVE A -> X\nSETA 2a")
+	var got []string
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Type == token.INT {
+			got = append(got, tok.Literal)
+		}
+	}
+	want := []string{"5", "-1", "0xFF", "1_000", "2a"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestNextToken_TokenizesExpressionOperators(t *testing.T) {
+	t.Parallel()
+	l := newLexerFromString("SETA ($+len(msg)*2-1) / 4 % 3 << 1 >> 2 & ~x | -1\nSETA -1")
+	var got []token.TokenType
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		got = append(got, tok.Type)
+	}
+	want := []token.TokenType{
+		token.INSTRUCTION, token.LPAREN, token.DOLLAR, token.PLUS, token.IDENT,
+		token.LPAREN, token.IDENT, token.RPAREN, token.ASTERISK, token.INT,
+		token.MINUS, token.INT, token.RPAREN, token.SLASH, token.INT,
+		token.PERCENT, token.INT, token.SHL, token.INT, token.SHR, token.INT,
+		token.AMPERSAND, token.TILDE, token.IDENT, token.PIPE, token.INT,
+		token.INSTRUCTION, token.INT,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if len(l.Errors()) > 0 {
+		t.Error("didn't expect an error:", l.Errors())
+	}
+}
+
+func TestNextToken_ReadsMinusAfterAnOperandAsSubtraction(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"SETA -1", []string{"SETA", "-1"}},
+		{"SETA 2-1", []string{"SETA", "2", "-", "1"}},
+		{"SETA x-1", []string{"SETA", "x", "-", "1"}},
+		{"SETA (2)-1", []string{"SETA", "(", "2", ")", "-", "1"}},
+		{"SETA 2*-1", []string{"SETA", "2", "*", "-1"}},
+		{"SETA -x", []string{"SETA", "-", "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := newLexerFromString(tt.input)
+			var got []string
+			for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+				got = append(got, tok.Literal)
+			}
+			if !cmp.Equal(tt.want, got) {
+				t.Error(cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		given string
+		want  string
+	}{
+		{`plain`, "plain"},
+		{`a\nb`, "a\nb"},
+		{`\t`, "\t"},
+		{`\\`, `\`},
+		{`say \"hi\"`, `say "hi"`},
+		{`\'`, `'`},
+		{`\0`, "\x00"},
+		{`\x41\x7e`, "A~"},
+		{`\u{1F600}`, "\U0001F600"},
+		{`\u{e9}t\u{E9}`, "été"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.given, func(t *testing.T) {
+			got, err := lexer.Unescape(tt.given)
+			if err != nil {
+				t.Fatal("didn't expect an error:", err)
+			}
+			if tt.want != got {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUnescape_ReturnsErrorForInvalidEscape(t *testing.T) {
+	t.Parallel()
+	for _, given := range []string{`\q`, `\`, `\x4`, `\xZZ`, `\u41`, `\u{}`, `\u{110000}`, `\u{D800}`, `\u{0000041}`} {
+		t.Run(given, func(t *testing.T) {
+			_, err := lexer.Unescape(given)
+			wantErr := lexer.ErrInvalidEscape
+			if !errors.Is(err, wantErr) {
+				t.Errorf("wanted error %v, got %v", wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNextToken_ReadsEscapesInCharacterAndStringLiterals(t *testing.T) {
+	t.Parallel()
+	l := newLexerFromString(`'\n' '\'' "say \"hi\"" '"' "it's" HALT`)
+	want := []token.Token{
+		{Type: token.CHAR, Literal: `'\n'`},
+		{Type: token.CHAR, Literal: `'\''`},
+		{Type: token.STRING, Literal: `say \"hi\"`},
+		{Type: token.CHAR, Literal: `'"'`},
+		{Type: token.STRING, Literal: `it's`},
+		{Type: token.INSTRUCTION, Literal: "HALT"},
+	}
+	for _, w := range want {
+		got := l.NextToken()
+		if w.Type != got.Type || w.Literal != got.Literal {
+			t.Errorf("wanted=%q [%s], got=%q [%s]", w.Literal, w.Type, got.Literal, got.Type)
+		}
+	}
+	if len(l.Errors()) > 0 {
+		t.Error("didn't expect an error:", l.Errors())
+	}
+}
+
+func TestNextToken_ReportsInvalidLiteralsWithoutConsumingTheNextLine(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input   string
+		wantErr error
+	}{
+		{"SETA '\nHALT", lexer.ErrInvalidCharacterLiteral},
+		{"SETA 'a\nHALT", lexer.ErrInvalidCharacterLiteral},
+		{"SETA 'ab'\nHALT", lexer.ErrInvalidCharacterLiteral},
+		{"SETA ''\nHALT", lexer.ErrInvalidCharacterLiteral},
+		{"SETA '\\q'\nHALT", lexer.ErrInvalidEscape},
+		{"VARB s \"abc\nHALT", lexer.ErrInvalidStringLiteral},
+		{"VARB s \"abc\\\"\nHALT", lexer.ErrInvalidStringLiteral},
+		{"VARB s \"\\u{zz}\"\nHALT", lexer.ErrInvalidEscape},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := newLexerFromString(tt.input)
+			var last token.Token
+			for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+				last = tok
+			}
+			if last.Literal != "HALT" || last.Line != 2 {
+				t.Errorf("want HALT on line 2 to be lexed, got %q on line %d", last.Literal, last.Line)
+			}
+			errs := l.Errors()
+			if len(errs) != 1 || !errors.Is(errs[0], tt.wantErr) {
+				t.Errorf("wanted error %v, got %v", tt.wantErr, errs)
+			}
+		})
+	}
+}
+
+func TestNewFile_RecordsFilenameInEachPosition(t *testing.T) {
+	t.Parallel()
+	l, err := lexer.NewFile("test.g", strings.NewReader("SETA @"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := l.NextToken()
+	want := "test.g:1:1"
+	if want != tok.Pos().String() {
+		t.Errorf("want position %s, got %s", want, tok.Pos())
+	}
+	l.NextToken()
+	want = "test.g:1:6"
+	if want != l.Errors()[0].Pos.String() {
+		t.Errorf("want error position %s, got %s", want, l.Errors()[0].Pos)
+	}
+}
@@ -0,0 +1,245 @@
+package gmachine
+
+import (
+	"errors"
+	"fmt"
+	"gmachine/ast"
+	"gmachine/lexer"
+	"gmachine/token"
+	"strings"
+)
+
+var ErrMacroRedefined error = errors.New("macro already defined")
+var ErrRecursiveMacro error = errors.New("recursive macro")
+var ErrMacroArguments error = errors.New("wrong number of macro arguments")
+
+// expansion records the macro call that a statement was expanded from, so
+// that an error in the statement can point to the call as well as to the
+// line of the macro's body it came from.
+type expansion struct {
+	macro string
+	call  token.Position
+	// parent is the expansion the call itself came from, if it's in the
+	// body of another macro.
+	parent *expansion
+}
+
+// wrap returns err as an error at the macro call, and at any calls it was
+// in turn expanded from, as in:
+//
+//	hello.g:12:1: in macro putc: hello.g:3:6: invalid operand: SETA
+func (x *expansion) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	for ; x != nil; x = x.parent {
+		err = &lexer.Error{Pos: x.call, Err: fmt.Errorf("in macro %s: %w", x.macro, err)}
+	}
+	return err
+}
+
+// statement is a statement of the program once its macros are expanded.
+type statement struct {
+	ast.Statement
+	expansion *expansion // the macro call the statement came from, if any
+}
+
+type macro struct {
+	ast.MacroDefinitionStatement
+	// locals holds the names of the labels, constants and variables defined
+	// in the macro's body, which are local to each expansion.
+	locals map[string]bool
+}
+
+type expander struct {
+	macros  map[string]*macro
+	invalid map[sourceLine]bool
+	// count is the number of expansions so far, which makes the local names
+	// of each one unique.
+	count int
+	errs  lexer.ErrorList
+}
+
+// expandMacros returns stmts with each macro call replaced by the body of
+// the macro, and the definitions removed. A macro can be called before the
+// line it's defined on. Calls on the invalid lines, which have syntax
+// errors, are left out.
+func expandMacros(stmts []ast.Statement, invalid map[sourceLine]bool) ([]statement, lexer.ErrorList) {
+	x := &expander{macros: make(map[string]*macro), invalid: invalid}
+	for _, stmt := range stmts {
+		if def, ok := stmt.(ast.MacroDefinitionStatement); ok {
+			x.define(def)
+		}
+	}
+	return x.expand(stmts, nil), x.errs
+}
+
+func (x *expander) define(def ast.MacroDefinitionStatement) {
+	name := def.Name.Value
+	if _, ok := x.macros[name]; ok {
+		x.errs.Add(def.Name.Pos(), fmt.Errorf("%w: %s", ErrMacroRedefined, name))
+		return
+	}
+	params := make(map[string]bool)
+	for _, param := range def.Params {
+		params[param.Value] = true
+	}
+	m := &macro{MacroDefinitionStatement: def, locals: make(map[string]bool)}
+	for _, stmt := range def.Body {
+		switch stmt := stmt.(type) {
+		case ast.LabelDefinitionStatement:
+			m.locals[strings.TrimPrefix(stmt.TokenLiteral(), ".")] = true
+		case ast.ConstantDefinitionStatement:
+			// A constant or variable named by a parameter takes the name
+			// of the argument instead, so it isn't local.
+			if !params[stmt.Name.Value] {
+				m.locals[stmt.Name.Value] = true
+			}
+		case ast.VariableDefinitionStatement:
+			if !params[stmt.Name.Value] {
+				m.locals[stmt.Name.Value] = true
+			}
+		}
+	}
+	x.macros[name] = m
+}
+
+func (x *expander) expand(stmts []ast.Statement, parent *expansion) []statement {
+	var expanded []statement
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case ast.MacroDefinitionStatement:
+			// Already defined.
+		case ast.MacroCallStatement:
+			if x.invalid[sourceLine{stmt.Token.Filename, stmt.Token.Line}] {
+				continue
+			}
+			expanded = append(expanded, x.call(stmt, parent)...)
+		default:
+			expanded = append(expanded, statement{Statement: stmt, expansion: parent})
+		}
+	}
+	return expanded
+}
+
+// call returns the statements of the macro called by call, with each of its
+// parameters replaced by the corresponding argument, and its labels,
+// constants and variables renamed so that they're unique to this expansion.
+func (x *expander) call(call ast.MacroCallStatement, parent *expansion) []statement {
+	name := call.TokenLiteral()
+	m, ok := x.macros[name]
+	if !ok {
+		x.addError(parent, errorAt(call, ErrUndefinedInstruction, name))
+		return nil
+	}
+	for e := parent; e != nil; e = e.parent {
+		if e.macro == name {
+			x.addError(parent, errorAt(call, ErrRecursiveMacro, name))
+			return nil
+		}
+	}
+	if len(call.Arguments) != len(m.Params) {
+		detail := fmt.Sprintf("%s takes %d, got %d", name, len(m.Params), len(call.Arguments))
+		x.addError(parent, errorAt(call, ErrMacroArguments, detail))
+		return nil
+	}
+
+	x.count++
+	s := substitution{
+		args:   make(map[string]ast.Expression),
+		locals: make(map[string]string),
+	}
+	for i, param := range m.Params {
+		s.args[param.Value] = call.Arguments[i]
+	}
+	for local := range m.locals {
+		s.locals[local] = fmt.Sprintf("%s@%d", local, x.count)
+	}
+	body := make([]ast.Statement, len(m.Body))
+	for i, stmt := range m.Body {
+		body[i] = s.statement(stmt)
+	}
+	return x.expand(body, &expansion{macro: name, call: call.Pos(), parent: parent})
+}
+
+func (x *expander) addError(parent *expansion, err error) {
+	var e *lexer.Error
+	if errors.As(parent.wrap(err), &e) {
+		x.errs = append(x.errs, e)
+	}
+}
+
+// substitution replaces the parameters of a macro with the arguments it's
+// called with, and renames the labels, constants and variables defined in
+// its body. An @ can't appear in a name in the source, so the new names
+// can't clash with any others.
+type substitution struct {
+	args   map[string]ast.Expression
+	locals map[string]string
+}
+
+func (s substitution) statement(stmt ast.Statement) ast.Statement {
+	switch stmt := stmt.(type) {
+	case ast.LabelDefinitionStatement:
+		if name, ok := s.locals[strings.TrimPrefix(stmt.TokenLiteral(), ".")]; ok {
+			stmt.Token.Literal = "." + name
+		}
+		return stmt
+	case ast.ConstantDefinitionStatement:
+		stmt.Name = s.name(stmt.Name)
+		stmt.Value = s.expression(stmt.Value)
+		return stmt
+	case ast.VariableDefinitionStatement:
+		stmt.Name = s.name(stmt.Name)
+		stmt.Value = s.expression(stmt.Value)
+		return stmt
+	case ast.InstructionStatement:
+		stmt.Operand1 = s.expression(stmt.Operand1)
+		stmt.Operand2 = s.expression(stmt.Operand2)
+		return stmt
+	case ast.MacroCallStatement:
+		args := make([]ast.Expression, len(stmt.Arguments))
+		for i, arg := range stmt.Arguments {
+			args[i] = s.expression(arg)
+		}
+		stmt.Arguments = args
+		return stmt
+	}
+	return stmt
+}
+
+// name returns the name a constant or variable is defined with. A parameter
+// can stand for the name, if its argument is an identifier.
+func (s substitution) name(ident ast.Identifier) ast.Identifier {
+	if arg, ok := s.args[ident.Value].(ast.Identifier); ok {
+		return arg
+	}
+	if name, ok := s.locals[ident.Value]; ok {
+		ident.Value = name
+	}
+	return ident
+}
+
+func (s substitution) expression(expr ast.Expression) ast.Expression {
+	switch expr := expr.(type) {
+	case ast.Identifier:
+		if arg, ok := s.args[expr.Value]; ok {
+			return arg
+		}
+		if name, ok := s.locals[expr.Value]; ok {
+			expr.Value = name
+		}
+		return expr
+	case ast.PrefixExpression:
+		expr.Right = s.expression(expr.Right)
+		return expr
+	case ast.InfixExpression:
+		expr.Left = s.expression(expr.Left)
+		expr.Right = s.expression(expr.Right)
+		return expr
+	case ast.CallExpression:
+		expr.Argument = s.expression(expr.Argument)
+		return expr
+	}
+	return expr
+}
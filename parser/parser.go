@@ -15,14 +15,46 @@ import (
 var ErrInvalidSyntax error = errors.New("invalid syntax")
 var ErrInvalidIntegerLiteral error = errors.New("invalid integer literal")
 
-type expressionParserFn func() ast.Expression
+type (
+	expressionParserFn func() ast.Expression
+	infixParserFn      func(ast.Expression) ast.Expression
+)
+
+// Operator precedences in constant expressions, from lowest to highest.
+// As in Go, multiplicative operators, shifts and & bind more tightly than
+// +, - and |.
+const (
+	_ int = iota
+	LOWEST
+	SUM     // + - |
+	PRODUCT // * / % << >> &
+	PREFIX  // -X or ~X
+	CALL    // len(X)
+)
+
+var precedences = map[token.TokenType]int{
+	token.PLUS:      SUM,
+	token.MINUS:     SUM,
+	token.PIPE:      SUM,
+	token.ASTERISK:  PRODUCT,
+	token.SLASH:     PRODUCT,
+	token.PERCENT:   PRODUCT,
+	token.SHL:       PRODUCT,
+	token.SHR:       PRODUCT,
+	token.AMPERSAND: PRODUCT,
+	token.LPAREN:    CALL,
+}
 
 type Parser struct {
-	l           *lexer.Lexer
-	curToken    token.Token
-	peekToken   token.Token
-	errors      []error
-	exprParsers map[token.TokenType]expressionParserFn
+	l            *lexer.Lexer
+	curToken     token.Token
+	peekToken    token.Token
+	errors       lexer.ErrorList
+	exprParsers  map[token.TokenType]expressionParserFn
+	infixParsers map[token.TokenType]infixParserFn
+	// failed is set once an error has been reported for the current
+	// statement, so that the rest of its line adds no further errors.
+	failed bool
 }
 
 func New(l *lexer.Lexer) *Parser {
@@ -34,6 +66,16 @@ func New(l *lexer.Lexer) *Parser {
 	p.exprParsers[token.INT] = p.parseIntegerLiteral
 	p.exprParsers[token.CHAR] = p.parseCharacterLiteral
 	p.exprParsers[token.STRING] = p.parseStringLiteral
+	p.exprParsers[token.DOLLAR] = p.parseCurrentAddress
+	p.exprParsers[token.MINUS] = p.parsePrefixExpression
+	p.exprParsers[token.TILDE] = p.parsePrefixExpression
+	p.exprParsers[token.LPAREN] = p.parseGroupedExpression
+
+	p.infixParsers = make(map[token.TokenType]infixParserFn)
+	for tokType := range precedences {
+		p.infixParsers[tokType] = p.parseInfixExpression
+	}
+	p.infixParsers[token.LPAREN] = p.parseCallExpression
 
 	// Read two tokens, so curToken and peekToken are both set
 	p.nextToken()
@@ -47,18 +89,69 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.curToken.Type != token.EOF {
-		stmt := p.parseStatement()
+		stmt := p.parseLine()
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
-		p.nextToken()
 	}
 
 	return program
 }
 
-func (p *Parser) Errors() []error {
-	return p.errors
+// parseLine parses the statement starting at the current token, and moves
+// on to the start of the next line.
+func (p *Parser) parseLine() ast.Statement {
+	p.failed = false
+	stmt := p.parseStatement()
+	if stmt == nil {
+		p.unexpected(p.curToken)
+	}
+	p.skipLine()
+	return stmt
+}
+
+// skipLine moves on to the start of the next line. Each statement occupies
+// a line of its own, so anything left on the current line is a mistake.
+// It's skipped, so that parsing picks up again at the start of the next
+// line.
+func (p *Parser) skipLine() {
+	line := p.curToken.Line
+	if p.onLine(p.peekToken, line) {
+		p.unexpected(p.peekToken)
+	}
+	for p.onLine(p.peekToken, line) {
+		p.nextToken()
+	}
+	p.nextToken()
+}
+
+// onLine reports whether tok is on the given line of the input.
+func (p *Parser) onLine(tok token.Token, line int) bool {
+	return tok.Type != token.EOF && tok.Line == line
+}
+
+// unexpected reports tok as not belonging where it was found, unless an
+// error has already been reported for the current statement. ILLEGAL tokens
+// have already been reported by the lexer.
+func (p *Parser) unexpected(tok token.Token) {
+	if p.failed || tok.Type == token.ILLEGAL {
+		p.failed = true
+		return
+	}
+	p.errorf(tok, "%w: unexpected %s %q", ErrInvalidSyntax, tok.Type, tok.Literal)
+}
+
+// Errors returns the problems found by the lexer and the parser, in the
+// order they appear in the input.
+func (p *Parser) Errors() lexer.ErrorList {
+	errs := append(slices.Clone(p.l.Errors()), p.errors...)
+	errs.Sort()
+	return errs
+}
+
+func (p *Parser) errorf(tok token.Token, format string, args ...any) {
+	p.errors.Add(tok.Pos(), fmt.Errorf(format, args...))
+	p.failed = true
 }
 
 func (p *Parser) nextToken() {
@@ -76,6 +169,12 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseConstantDefinitionStatement()
 	case token.VARIABLE_DEFINITION:
 		return p.parseVariableDefinitionStatement()
+	case token.MACRO_DEFINITION:
+		return p.parseMacroDefinitionStatement()
+	case token.INCLUDE:
+		return p.parseIncludeStatement()
+	case token.IDENT:
+		return p.parseMacroCallStatement()
 	default:
 		return nil
 	}
@@ -83,15 +182,91 @@ func (p *Parser) parseStatement() ast.Statement {
 
 func (p *Parser) parseVariableDefinitionStatement() ast.Statement {
 	stmt := ast.VariableDefinitionStatement{Token: p.curToken}
-	stmt.Name = p.expectOneOf(token.IDENT).(ast.Identifier)
-	stmt.Value = p.expectOneOf(token.INT, token.STRING)
+	stmt.Name, _ = p.expectOneOf(token.IDENT).(ast.Identifier)
+	stmt.Value = p.expectExpression()
 	return stmt
 }
 
 func (p *Parser) parseConstantDefinitionStatement() ast.Statement {
 	stmt := ast.ConstantDefinitionStatement{Token: p.curToken}
-	stmt.Name = p.expectOneOf(token.IDENT).(ast.Identifier)
-	stmt.Value = p.expectOneOf(token.INT)
+	stmt.Name, _ = p.expectOneOf(token.IDENT).(ast.Identifier)
+	stmt.Value = p.expectExpression()
+	return stmt
+}
+
+// parseMacroDefinitionStatement parses a macro definition, which runs from
+// its MACRO line up to the ENDM line closing it, leaving the current token
+// at the ENDM.
+func (p *Parser) parseMacroDefinitionStatement() ast.Statement {
+	stmt := ast.MacroDefinitionStatement{Token: p.curToken}
+	stmt.Name, _ = p.expectOneOf(token.IDENT).(ast.Identifier)
+	if !p.failed && p.onLine(p.peekToken, p.curToken.Line) {
+		for {
+			param, ok := p.expectOneOf(token.IDENT).(ast.Identifier)
+			if !ok {
+				break
+			}
+			stmt.Params = append(stmt.Params, param)
+			if !p.onLine(p.peekToken, p.curToken.Line) || p.peekToken.Type != token.COMMA {
+				break
+			}
+			p.nextToken()
+		}
+	}
+	p.skipLine()
+
+	for p.curToken.Type != token.MACRO_END {
+		switch p.curToken.Type {
+		case token.EOF:
+			p.errors.Add(stmt.Token.Pos(), fmt.Errorf("%w: MACRO %s has no ENDM", ErrInvalidSyntax, stmt.Name.Value))
+			return stmt
+		case token.MACRO_DEFINITION:
+			p.failed = false
+			p.errorf(p.curToken, "%w: macro definitions can't be nested", ErrInvalidSyntax)
+			p.skipLine()
+		case token.INCLUDE:
+			p.failed = false
+			p.errorf(p.curToken, "%w: INCLUDE can't be used in a macro", ErrInvalidSyntax)
+			p.skipLine()
+		default:
+			body := p.parseLine()
+			if body != nil {
+				stmt.Body = append(stmt.Body, body)
+			}
+		}
+	}
+	stmt.Endm = p.curToken
+	p.failed = false
+	return stmt
+}
+
+func (p *Parser) parseIncludeStatement() ast.Statement {
+	stmt := ast.IncludeStatement{Token: p.curToken}
+	stmt.Path, _ = p.expectOneOf(token.STRING).(ast.StringLiteral)
+	return stmt
+}
+
+// parseMacroCallStatement parses a call to a macro, with its arguments
+// separated by commas.
+func (p *Parser) parseMacroCallStatement() ast.Statement {
+	stmt := ast.MacroCallStatement{Token: p.curToken}
+	if !p.onLine(p.peekToken, p.curToken.Line) {
+		return stmt
+	}
+	if _, ok := p.exprParsers[p.peekToken.Type]; !ok {
+		return stmt
+	}
+	for {
+		arg := p.expectExpression()
+		if arg == nil {
+			break
+		}
+		stmt.Arguments = append(stmt.Arguments, arg)
+		if !p.onLine(p.peekToken, p.curToken.Line) || p.peekToken.Type != token.COMMA {
+			break
+		}
+		p.nextToken()
+	}
 	return stmt
 }
 
@@ -100,16 +275,24 @@ func (p *Parser) parseLabelDefinitionStatement() ast.Statement {
 }
 
 func (p *Parser) expectOneOf(tokTypes ...token.TokenType) ast.Expression {
+	if !p.expectOnLine(fmt.Sprintf("one of %+v", tokTypes)) {
+		return nil
+	}
 	p.nextToken()
 	if !slices.Contains(tokTypes, p.curToken.Type) {
-		p.errors = append(p.errors, fmt.Errorf("%w: expected one of %+v, got %s at line %d", ErrInvalidSyntax, tokTypes, p.curToken.Type, p.curToken.Line))
+		if p.curToken.Type == token.ILLEGAL {
+			p.failed = true
+			return nil
+		}
+		p.errorf(p.curToken, "%w: expected one of %+v, got %s", ErrInvalidSyntax, tokTypes, p.curToken.Type)
+		return nil
 	}
 
 	switch p.curToken.Type {
 	case token.ASTERISK:
+		// expectOneOf has already reported a missing register.
 		expr := p.expectOneOf(token.REGISTER)
 		if expr == nil {
-			p.errors = append(p.errors, fmt.Errorf("%w: expected one of %+v, got %s at line %d", ErrInvalidSyntax, tokTypes, p.curToken.Type, p.curToken.Line))
 			return nil
 		}
 		regLiteral := expr.(ast.RegisterLiteral)
@@ -132,19 +315,134 @@ func (p *Parser) parseInstructionStatement() ast.Statement {
 	stmt := ast.InstructionStatement{Token: p.curToken}
 
 	if stmt.TokenLiteral() == "MOVE" {
-		stmt.Operand1 = p.expectOneOf(token.ASTERISK, token.REGISTER, token.IDENT)
+		if p.peekToken.Type == token.ASTERISK {
+			stmt.Operand1 = p.expectOneOf(token.ASTERISK)
+		} else {
+			stmt.Operand1 = p.expectExpression()
+		}
 		p.expectOneOf(token.ARROW)
-		stmt.Operand2 = p.expectOneOf(token.REGISTER, token.IDENT)
+		stmt.Operand2 = p.expectExpression()
+		return stmt
 	}
 
-	if exprParser, ok := p.exprParsers[p.peekToken.Type]; ok {
+	if _, ok := p.exprParsers[p.peekToken.Type]; ok && p.onLine(p.peekToken, stmt.Token.Line) {
 		p.nextToken()
-		stmt.Operand1 = exprParser()
+		stmt.Operand1 = p.parseExpression(LOWEST)
 	}
 
 	return stmt
 }
 
+// expectOnLine reports whether the next token is on the same line as the
+// current one, as the operands of a statement must be. If it isn't, it
+// reports that what was wanted is missing.
+func (p *Parser) expectOnLine(wanted string) bool {
+	if p.failed {
+		return false
+	}
+	if !p.onLine(p.peekToken, p.curToken.Line) {
+		p.errors.Add(p.curToken.End, fmt.Errorf("%w: expected %s, got end of line", ErrInvalidSyntax, wanted))
+		p.failed = true
+		return false
+	}
+	return true
+}
+
+// expectExpression parses the expression that follows the current token.
+func (p *Parser) expectExpression() ast.Expression {
+	if !p.expectOnLine("expression") {
+		return nil
+	}
+	p.nextToken()
+	return p.parseExpression(LOWEST)
+}
+
+// parseExpression parses the expression starting at the current token,
+// taking in operators that bind more tightly than precedence. It returns nil
+// if the expression is invalid, having reported why.
+func (p *Parser) parseExpression(precedence int) ast.Expression {
+	exprParser, ok := p.exprParsers[p.curToken.Type]
+	if !ok {
+		p.unexpected(p.curToken)
+		return nil
+	}
+	left := exprParser()
+	for left != nil && p.onLine(p.peekToken, p.curToken.Line) && precedence < precedences[p.peekToken.Type] {
+		infixParser := p.infixParsers[p.peekToken.Type]
+		p.nextToken()
+		left = infixParser(left)
+	}
+	return left
+}
+
+func (p *Parser) parsePrefixExpression() ast.Expression {
+	expr := ast.PrefixExpression{Token: p.curToken, Operator: p.curToken.Literal}
+	if !p.expectOnLine("expression") {
+		return nil
+	}
+	p.nextToken()
+	expr.Right = p.parseExpression(PREFIX)
+	if expr.Right == nil {
+		return nil
+	}
+	return expr
+}
+
+func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
+	expr := ast.InfixExpression{Token: p.curToken, Left: left, Operator: p.curToken.Literal}
+	precedence := precedences[p.curToken.Type]
+	if !p.expectOnLine("expression") {
+		return nil
+	}
+	p.nextToken()
+	expr.Right = p.parseExpression(precedence)
+	if expr.Right == nil {
+		return nil
+	}
+	return expr
+}
+
+func (p *Parser) parseGroupedExpression() ast.Expression {
+	expr := p.expectExpression()
+	if expr == nil || !p.expectClosingParen() {
+		return nil
+	}
+	return expr
+}
+
+func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
+	ident, ok := function.(ast.Identifier)
+	if !ok {
+		p.unexpected(p.curToken)
+		return nil
+	}
+	expr := ast.CallExpression{Token: p.curToken, Function: ident}
+	expr.Argument = p.expectExpression()
+	if expr.Argument == nil || !p.expectClosingParen() {
+		return nil
+	}
+	expr.Rparen = p.curToken
+	return expr
+}
+
+// expectClosingParen reports whether the next token closes a parenthesis,
+// and moves on to it if so.
+func (p *Parser) expectClosingParen() bool {
+	if !p.expectOnLine(`")"`) {
+		return false
+	}
+	p.nextToken()
+	if p.curToken.Type != token.RPAREN {
+		p.unexpected(p.curToken)
+		return false
+	}
+	return true
+}
+
+func (p *Parser) parseCurrentAddress() ast.Expression {
+	return ast.CurrentAddress{Token: p.curToken}
+}
+
 func (p *Parser) parseRegisterLiteral() ast.Expression {
 	return ast.RegisterLiteral{Token: p.curToken}
 }
@@ -156,9 +454,9 @@ func (p *Parser) parseIdentifier() ast.Expression {
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	intLiteral := ast.IntegerLiteral{Token: p.curToken}
 
-	value, err := strconv.ParseUint(intLiteral.TokenLiteral(), 0, 64)
+	value, err := parseInteger(intLiteral.TokenLiteral())
 	if err != nil {
-		p.errors = append(p.errors, fmt.Errorf("%w: %s at line %d", ErrInvalidIntegerLiteral, intLiteral.TokenLiteral(), intLiteral.Token.Line))
+		p.errorf(intLiteral.Token, "%w: %s", ErrInvalidIntegerLiteral, intLiteral.TokenLiteral())
 		return nil
 	}
 
@@ -167,16 +465,42 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return intLiteral
 }
 
+// parseInteger parses a decimal, 0x hex, 0b binary or 0o octal literal, which
+// may contain underscores between digits. A negative literal is encoded as
+// its 64-bit two's complement.
+func parseInteger(literal string) (uint64, error) {
+	digits, negative := strings.CutPrefix(literal, "-")
+	value, err := strconv.ParseUint(digits, 0, 64)
+	if err != nil {
+		return 0, err
+	}
+	if !negative {
+		return value, nil
+	}
+	if value > 1<<63 {
+		return 0, strconv.ErrRange
+	}
+	return -value, nil
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
-	return ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	value, err := lexer.Unescape(p.curToken.Literal)
+	if err != nil {
+		p.errorf(p.curToken, "%w", err)
+		return nil
+	}
+	return ast.StringLiteral{Token: p.curToken, Value: value}
 }
 
 func (p *Parser) parseCharacterLiteral() ast.Expression {
 	charLiteral := ast.CharacterLiteral{Token: p.curToken}
 
-	// TODO: Handle errors
-	char, size := utf8.DecodeRuneInString(strings.Trim(charLiteral.TokenLiteral(), "'"))
-	if size == 0 {
+	// The lexer has already checked that the literal holds a single
+	// character, or a valid escape sequence for one.
+	value, err := lexer.Unescape(strings.TrimSuffix(strings.TrimPrefix(charLiteral.TokenLiteral(), "'"), "'"))
+	char, size := utf8.DecodeRuneInString(value)
+	if err != nil || size == 0 {
+		p.errorf(charLiteral.Token, "%w: %s", lexer.ErrInvalidCharacterLiteral, charLiteral.TokenLiteral())
 		return nil
 	}
 
@@ -29,6 +29,9 @@ func TestParseProgram_ParsesLabelDefinitions(t *testing.T) {
 				Type:    token.LABEL_DEFINITION,
 				Literal: ".test",
 				Line:    1,
+				Column:  1,
+				Offset:  0,
+				End:     token.Position{Offset: 5, Line: 1, Column: 6},
 			},
 		},
 	}
@@ -55,12 +58,18 @@ func TestParseProgram_ParsesConstantDefinition(t *testing.T) {
 				Type:    token.CONSTANT_DEFINITION,
 				Literal: "CONS",
 				Line:    1,
+				Column:  1,
+				Offset:  0,
+				End:     token.Position{Offset: 4, Line: 1, Column: 5},
 			},
 			Name: ast.Identifier{
 				Token: token.Token{
 					Type:    token.IDENT,
 					Literal: "c",
 					Line:    1,
+					Column:  6,
+					Offset:  5,
+					End:     token.Position{Offset: 6, Line: 1, Column: 7},
 				},
 				Value: "c",
 			},
@@ -69,6 +78,9 @@ func TestParseProgram_ParsesConstantDefinition(t *testing.T) {
 					Type:    token.INT,
 					Literal: "10",
 					Line:    1,
+					Column:  8,
+					Offset:  7,
+					End:     token.Position{Offset: 9, Line: 1, Column: 10},
 				},
 				Value: 10,
 			},
@@ -98,12 +110,18 @@ func TestParseProgram_ParsesStringVariableDefinition(t *testing.T) {
 				Type:    token.VARIABLE_DEFINITION,
 				Literal: "VARB",
 				Line:    1,
+				Column:  1,
+				Offset:  0,
+				End:     token.Position{Offset: 4, Line: 1, Column: 5},
 			},
 			Name: ast.Identifier{
 				Token: token.Token{
 					Type:    token.IDENT,
 					Literal: "msg",
 					Line:    1,
+					Column:  6,
+					Offset:  5,
+					End:     token.Position{Offset: 8, Line: 1, Column: 9},
 				},
 				Value: "msg",
 			},
@@ -112,6 +130,9 @@ func TestParseProgram_ParsesStringVariableDefinition(t *testing.T) {
 					Type:    token.STRING,
 					Literal: "hello",
 					Line:    1,
+					Column:  10,
+					Offset:  9,
+					End:     token.Position{Offset: 16, Line: 1, Column: 17},
 				},
 				Value: "hello",
 			},
@@ -141,12 +162,18 @@ func TestParseProgram_ParsesIntegerVariableDefinition(t *testing.T) {
 				Type:    token.VARIABLE_DEFINITION,
 				Literal: "VARB",
 				Line:    1,
+				Column:  1,
+				Offset:  0,
+				End:     token.Position{Offset: 4, Line: 1, Column: 5},
 			},
 			Name: ast.Identifier{
 				Token: token.Token{
 					Type:    token.IDENT,
 					Literal: "num",
 					Line:    1,
+					Column:  6,
+					Offset:  5,
+					End:     token.Position{Offset: 8, Line: 1, Column: 9},
 				},
 				Value: "num",
 			},
@@ -155,6 +182,9 @@ func TestParseProgram_ParsesIntegerVariableDefinition(t *testing.T) {
 					Type:    token.INT,
 					Literal: "100",
 					Line:    1,
+					Column:  10,
+					Offset:  9,
+					End:     token.Position{Offset: 12, Line: 1, Column: 13},
 				},
 				Value: 100,
 			},
@@ -191,6 +221,9 @@ POPA`
 				Type:    token.INSTRUCTION,
 				Literal: "HALT",
 				Line:    2,
+				Column:  1,
+				Offset:  1,
+				End:     token.Position{Offset: 5, Line: 2, Column: 5},
 			},
 		},
 		ast.InstructionStatement{
@@ -198,6 +231,9 @@ POPA`
 				Type:    token.INSTRUCTION,
 				Literal: "NOOP",
 				Line:    3,
+				Column:  1,
+				Offset:  6,
+				End:     token.Position{Offset: 10, Line: 3, Column: 5},
 			},
 		},
 		ast.InstructionStatement{
@@ -205,6 +241,9 @@ POPA`
 				Type:    token.INSTRUCTION,
 				Literal: "OUTA",
 				Line:    4,
+				Column:  1,
+				Offset:  11,
+				End:     token.Position{Offset: 15, Line: 4, Column: 5},
 			},
 		},
 		ast.InstructionStatement{
@@ -212,6 +251,9 @@ POPA`
 				Type:    token.INSTRUCTION,
 				Literal: "INCA",
 				Line:    5,
+				Column:  1,
+				Offset:  16,
+				End:     token.Position{Offset: 20, Line: 5, Column: 5},
 			},
 		},
 		ast.InstructionStatement{
@@ -219,6 +261,9 @@ POPA`
 				Type:    token.INSTRUCTION,
 				Literal: "DECA",
 				Line:    6,
+				Column:  1,
+				Offset:  21,
+				End:     token.Position{Offset: 25, Line: 6, Column: 5},
 			},
 		},
 		ast.InstructionStatement{
@@ -226,6 +271,9 @@ POPA`
 				Type:    token.INSTRUCTION,
 				Literal: "PSHA",
 				Line:    7,
+				Column:  1,
+				Offset:  26,
+				End:     token.Position{Offset: 30, Line: 7, Column: 5},
 			},
 		},
 		ast.InstructionStatement{
@@ -233,6 +281,9 @@ POPA`
 				Type:    token.INSTRUCTION,
 				Literal: "POPA",
 				Line:    8,
+				Column:  1,
+				Offset:  31,
+				End:     token.Position{Offset: 35, Line: 8, Column: 5},
 			},
 		},
 	}
@@ -283,12 +334,18 @@ JUMP 42
 				Type:    token.INSTRUCTION,
 				Literal: "SETA",
 				Line:    2,
+				Column:  1,
+				Offset:  1,
+				End:     token.Position{Offset: 5, Line: 2, Column: 5},
 			},
 			Operand1: ast.IntegerLiteral{
 				Token: token.Token{
 					Type:    token.INT,
 					Literal: "42",
 					Line:    2,
+					Column:  6,
+					Offset:  6,
+					End:     token.Position{Offset: 8, Line: 2, Column: 8},
 				},
 				Value: 42,
 			},
@@ -298,12 +355,18 @@ JUMP 42
 				Type:    token.INSTRUCTION,
 				Literal: "JUMP",
 				Line:    3,
+				Column:  1,
+				Offset:  9,
+				End:     token.Position{Offset: 13, Line: 3, Column: 5},
 			},
 			Operand1: ast.IntegerLiteral{
 				Token: token.Token{
 					Type:    token.INT,
 					Literal: "42",
 					Line:    3,
+					Column:  6,
+					Offset:  14,
+					End:     token.Position{Offset: 16, Line: 3, Column: 8},
 				},
 				Value: 42,
 			},
@@ -332,12 +395,18 @@ func TestParseProgram_ParsesInstructionWithAnIdentifierOperand(t *testing.T) {
 				Type:    token.INSTRUCTION,
 				Literal: "JUMP",
 				Line:    1,
+				Column:  1,
+				Offset:  0,
+				End:     token.Position{Offset: 4, Line: 1, Column: 5},
 			},
 			Operand1: ast.Identifier{
 				Token: token.Token{
 					Type:    token.IDENT,
 					Literal: "start",
 					Line:    1,
+					Column:  6,
+					Offset:  5,
+					End:     token.Position{Offset: 10, Line: 1, Column: 11},
 				},
 				Value: "start",
 			},
@@ -369,12 +438,18 @@ MOVE var -> A
 				Type:    token.INSTRUCTION,
 				Literal: "MOVE",
 				Line:    2,
+				Column:  1,
+				Offset:  1,
+				End:     token.Position{Offset: 5, Line: 2, Column: 5},
 			},
 			Operand1: ast.RegisterLiteral{
 				Token: token.Token{
 					Type:    token.REGISTER,
 					Literal: "A",
 					Line:    2,
+					Column:  6,
+					Offset:  6,
+					End:     token.Position{Offset: 7, Line: 2, Column: 7},
 				},
 			},
 			Operand2: ast.Identifier{
@@ -382,6 +457,9 @@ MOVE var -> A
 					Type:    token.IDENT,
 					Literal: "var",
 					Line:    2,
+					Column:  11,
+					Offset:  11,
+					End:     token.Position{Offset: 14, Line: 2, Column: 14},
 				},
 				Value: "var",
 			},
@@ -391,12 +469,18 @@ MOVE var -> A
 				Type:    token.INSTRUCTION,
 				Literal: "MOVE",
 				Line:    3,
+				Column:  1,
+				Offset:  15,
+				End:     token.Position{Offset: 19, Line: 3, Column: 5},
 			},
 			Operand1: ast.Identifier{
 				Token: token.Token{
 					Type:    token.IDENT,
 					Literal: "var",
 					Line:    3,
+					Column:  6,
+					Offset:  20,
+					End:     token.Position{Offset: 23, Line: 3, Column: 9},
 				},
 				Value: "var",
 			},
@@ -405,6 +489,9 @@ MOVE var -> A
 					Type:    token.REGISTER,
 					Literal: "A",
 					Line:    3,
+					Column:  13,
+					Offset:  27,
+					End:     token.Position{Offset: 28, Line: 3, Column: 14},
 				},
 			},
 		},
@@ -438,12 +525,18 @@ ADDA Y
 				Type:    token.INSTRUCTION,
 				Literal: "MOVE",
 				Line:    2,
+				Column:  1,
+				Offset:  1,
+				End:     token.Position{Offset: 5, Line: 2, Column: 5},
 			},
 			Operand1: ast.RegisterLiteral{
 				Token: token.Token{
 					Type:    token.REGISTER,
 					Literal: "A",
 					Line:    2,
+					Column:  6,
+					Offset:  6,
+					End:     token.Position{Offset: 7, Line: 2, Column: 7},
 				},
 			},
 			Operand2: ast.RegisterLiteral{
@@ -451,6 +544,9 @@ ADDA Y
 					Type:    token.REGISTER,
 					Literal: "X",
 					Line:    2,
+					Column:  11,
+					Offset:  11,
+					End:     token.Position{Offset: 12, Line: 2, Column: 12},
 				},
 			},
 		},
@@ -459,12 +555,18 @@ ADDA Y
 				Type:    token.INSTRUCTION,
 				Literal: "MOVE",
 				Line:    3,
+				Column:  1,
+				Offset:  13,
+				End:     token.Position{Offset: 17, Line: 3, Column: 5},
 			},
 			Operand1: ast.RegisterLiteral{
 				Token: token.Token{
 					Type:    token.REGISTER,
 					Literal: "A",
 					Line:    3,
+					Column:  7,
+					Offset:  19,
+					End:     token.Position{Offset: 20, Line: 3, Column: 8},
 				},
 				Dereferenced: true,
 			},
@@ -473,6 +575,9 @@ ADDA Y
 					Type:    token.REGISTER,
 					Literal: "X",
 					Line:    3,
+					Column:  12,
+					Offset:  24,
+					End:     token.Position{Offset: 25, Line: 3, Column: 13},
 				},
 			},
 		},
@@ -481,12 +586,18 @@ ADDA Y
 				Type:    token.INSTRUCTION,
 				Literal: "MOVE",
 				Line:    4,
+				Column:  1,
+				Offset:  26,
+				End:     token.Position{Offset: 30, Line: 4, Column: 5},
 			},
 			Operand1: ast.RegisterLiteral{
 				Token: token.Token{
 					Type:    token.REGISTER,
 					Literal: "A",
 					Line:    4,
+					Column:  6,
+					Offset:  31,
+					End:     token.Position{Offset: 32, Line: 4, Column: 7},
 				},
 			},
 			Operand2: ast.RegisterLiteral{
@@ -494,6 +605,9 @@ ADDA Y
 					Type:    token.REGISTER,
 					Literal: "Y",
 					Line:    4,
+					Column:  11,
+					Offset:  36,
+					End:     token.Position{Offset: 37, Line: 4, Column: 12},
 				},
 			},
 		},
@@ -502,12 +616,18 @@ ADDA Y
 				Type:    token.INSTRUCTION,
 				Literal: "ADDA",
 				Line:    5,
+				Column:  1,
+				Offset:  38,
+				End:     token.Position{Offset: 42, Line: 5, Column: 5},
 			},
 			Operand1: ast.RegisterLiteral{
 				Token: token.Token{
 					Type:    token.REGISTER,
 					Literal: "X",
 					Line:    5,
+					Column:  6,
+					Offset:  43,
+					End:     token.Position{Offset: 44, Line: 5, Column: 7},
 				},
 			},
 		},
@@ -516,12 +636,18 @@ ADDA Y
 				Type:    token.INSTRUCTION,
 				Literal: "ADDA",
 				Line:    6,
+				Column:  1,
+				Offset:  45,
+				End:     token.Position{Offset: 49, Line: 6, Column: 5},
 			},
 			Operand1: ast.RegisterLiteral{
 				Token: token.Token{
 					Type:    token.REGISTER,
 					Literal: "Y",
 					Line:    6,
+					Column:  6,
+					Offset:  50,
+					End:     token.Position{Offset: 51, Line: 6, Column: 7},
 				},
 			},
 		},
@@ -549,12 +675,18 @@ func TestParseProgram_ParsesInstructionWithACharacterLiteralOperand(t *testing.T
 				Type:    token.INSTRUCTION,
 				Literal: "SETA",
 				Line:    1,
+				Column:  1,
+				Offset:  0,
+				End:     token.Position{Offset: 4, Line: 1, Column: 5},
 			},
 			Operand1: ast.CharacterLiteral{
 				Token: token.Token{
 					Type:    token.CHAR,
 					Literal: "'a'",
 					Line:    1,
+					Column:  6,
+					Offset:  5,
+					End:     token.Position{Offset: 8, Line: 1, Column: 9},
 				},
 				Value: 'a',
 			},
@@ -573,3 +705,361 @@ func newLexerFromString(input string) *lexer.Lexer {
 	}
 	return l
 }
+
+func TestParseProgram_RecordsSpanOfEachStatement(t *testing.T) {
+	t.Parallel()
+
+	input := "MOVE *A -> X\n  VARB msg \"hi\"\nHALT"
+	l := newLexerFromString(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	type span struct{ Pos, End token.Position }
+	want := []span{
+		{token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 12, Line: 1, Column: 13}},
+		{token.Position{Offset: 15, Line: 2, Column: 3}, token.Position{Offset: 28, Line: 2, Column: 16}},
+		{token.Position{Offset: 29, Line: 3, Column: 1}, token.Position{Offset: 33, Line: 3, Column: 5}},
+	}
+	var got []span
+	for _, stmt := range program.Statements {
+		got = append(got, span{stmt.Pos(), stmt.End()})
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestParseProgram_ReportsUnexpectedTokensAndResumesAtNextLine(t *testing.T) {
+	t.Parallel()
+
+	input := `"hello" world
+HALT
+INCA INCX
+5
+MOVE A X
+MOVE A
+OUTA`
+	l := newLexerFromString(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	type position struct{ Line, Column int }
+	wantErrs := []position{{1, 1}, {3, 6}, {4, 1}, {5, 8}, {6, 7}}
+	var gotErrs []position
+	for _, err := range p.Errors() {
+		if !errors.Is(err, parser.ErrInvalidSyntax) {
+			t.Errorf("wanted error %v, got %v", parser.ErrInvalidSyntax, err)
+		}
+		gotErrs = append(gotErrs, position{err.Pos.Line, err.Pos.Column})
+	}
+	if !cmp.Equal(wantErrs, gotErrs) {
+		t.Error(cmp.Diff(wantErrs, gotErrs))
+	}
+
+	var want, got []string
+	want = []string{"HALT", "INCA", "MOVE", "MOVE", "OUTA"}
+	for _, stmt := range program.Statements {
+		got = append(got, stmt.TokenLiteral())
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestParseProgram_ReportsIllegalTokensOnce(t *testing.T) {
+	t.Parallel()
+
+	l := newLexerFromString("SETA @\n@ HALT\nHALT")
+	p := parser.New(l)
+	p.ParseProgram()
+
+	errs := p.Errors()
+	if len(errs) != 2 {
+		t.Fatalf("want 2 errors, got %d:\n%v", len(errs), errs)
+	}
+	for _, err := range errs {
+		if !errors.Is(err, lexer.ErrIllegalCharacter) {
+			t.Errorf("wanted error %v, got %v", lexer.ErrIllegalCharacter, err)
+		}
+	}
+}
+
+func TestParseProgram_DoesNotTakeOperandsFromTheNextLine(t *testing.T) {
+	t.Parallel()
+
+	l := newLexerFromString("VARB msg\n\"hi\"\nMOVE A ->\nX")
+	p := parser.New(l)
+	p.ParseProgram()
+
+	type position struct{ Line, Column int }
+	want := []position{{1, 9}, {2, 1}, {3, 10}, {4, 1}}
+	var got []position
+	for _, err := range p.Errors() {
+		got = append(got, position{err.Pos.Line, err.Pos.Column})
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestParseProgram_ParsesIntegerLiteralForms(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input string
+		want  uint64
+	}{
+		{"SETA 42", 42},
+		{"SETA 0xFF", 0xff},
+		{"SETA 0Xff", 0xff},
+		{"SETA 0b1010", 10},
+		{"SETA 0o17", 15},
+		{"SETA 1_000_000", 1000000},
+		{"SETA 0xFFFF_FFFF_FFFF_FFFF", 1<<64 - 1},
+		{"SETA -1", 1<<64 - 1},
+		{"SETA -0x10", 1<<64 - 16},
+		{"SETA -9223372036854775808", 1 << 63},
+		{"SETA 5 ; a comment", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := parser.New(newLexerFromString(tt.input))
+			program := p.ParseProgram()
+			if len(p.Errors()) > 0 {
+				t.Fatal("didn't expect an error:", p.Errors())
+			}
+			stmt := program.Statements[0].(ast.InstructionStatement)
+			got := stmt.Operand1.(ast.IntegerLiteral).Value
+			if tt.want != got {
+				t.Errorf("want %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseProgram_RejectsInvalidIntegerLiterals(t *testing.T) {
+	t.Parallel()
+	for _, input := range []string{
+		"SETA 0xG",
+		"SETA 1__0",
+		"SETA 18446744073709551616",
+		"SETA -9223372036854775809",
+		"SETA 0b102",
+	} {
+		t.Run(input, func(t *testing.T) {
+			p := parser.New(newLexerFromString(input))
+			p.ParseProgram()
+			errs := p.Errors()
+			wantErr := parser.ErrInvalidIntegerLiteral
+			if len(errs) != 1 || !errors.Is(errs[0], wantErr) {
+				t.Errorf("wanted error %v, got %v", wantErr, errs)
+			}
+		})
+	}
+}
+
+// format returns expr with each operation in parentheses, to show how it
+// was grouped.
+func format(expr ast.Expression) string {
+	switch expr := expr.(type) {
+	case ast.PrefixExpression:
+		return "(" + expr.Operator + format(expr.Right) + ")"
+	case ast.InfixExpression:
+		return "(" + format(expr.Left) + " " + expr.Operator + " " + format(expr.Right) + ")"
+	case ast.CallExpression:
+		return expr.Function.Value + "(" + format(expr.Argument) + ")"
+	default:
+		return expr.TokenLiteral()
+	}
+}
+
+func TestParseProgram_ParsesExpressionsByPrecedence(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"SETA 1+2*3", "(1 + (2 * 3))"},
+		{"SETA (1+2)*3", "((1 + 2) * 3)"},
+		{"SETA 10-2-3", "((10 - 2) - 3)"},
+		{"SETA 1<<4|1", "((1 << 4) | 1)"},
+		{"SETA x&0xff+1", "((x & 0xff) + 1)"},
+		{"SETA -x*2", "((-x) * 2)"},
+		{"SETA ~-1", "(~-1)"},
+		{"SETA $+2", "($ + 2)"},
+		{"SETA msg+len(msg)-1", "((msg + len(msg)) - 1)"},
+		{"CONS SIZE 4*8", "(4 * 8)"},
+		{"VARB end $", "$"},
+		{"MOVE A -> msg+1", "(msg + 1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := parser.New(newLexerFromString(tt.input))
+			program := p.ParseProgram()
+			if len(p.Errors()) > 0 {
+				t.Fatal("didn't expect an error:", p.Errors())
+			}
+			var expr ast.Expression
+			switch stmt := program.Statements[0].(type) {
+			case ast.InstructionStatement:
+				expr = stmt.Operand1
+				if stmt.Operand2 != nil {
+					expr = stmt.Operand2
+				}
+			case ast.ConstantDefinitionStatement:
+				expr = stmt.Value
+			case ast.VariableDefinitionStatement:
+				expr = stmt.Value
+			}
+			got := format(expr)
+			if tt.want != got {
+				t.Errorf("want %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseProgram_RecordsSpanOfExpressions(t *testing.T) {
+	t.Parallel()
+	p := parser.New(newLexerFromString("SETA len(msg)+1"))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatal("didn't expect an error:", p.Errors())
+	}
+	expr := program.Statements[0].(ast.InstructionStatement).Operand1
+	wantPos := token.Position{Offset: 5, Line: 1, Column: 6}
+	wantEnd := token.Position{Offset: 15, Line: 1, Column: 16}
+	if wantPos != expr.Pos() || wantEnd != expr.End() {
+		t.Errorf("want span %s-%s, got %s-%s", wantPos, wantEnd, expr.Pos(), expr.End())
+	}
+}
+
+func TestParseProgram_RejectsInvalidExpressions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input   string
+		wantPos string
+	}{
+		{"SETA 1+", "1:8"},
+		{"SETA (1+2", "1:10"},
+		{"SETA (1+2 3", "1:11"},
+		{"SETA 1+*2", "1:8"},
+		{"SETA 2(3)", "1:7"},
+		{"CONS SIZE", "1:10"},
+		{"CONS SIZE ->", "1:11"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := parser.New(newLexerFromString(tt.input + "\nHALT"))
+			program := p.ParseProgram()
+			errs := p.Errors()
+			wantErr := parser.ErrInvalidSyntax
+			if len(errs) != 1 || !errors.Is(errs[0], wantErr) {
+				t.Fatalf("wanted error %v, got %v", wantErr, errs)
+			}
+			if tt.wantPos != errs[0].Pos.String() {
+				t.Errorf("want position %s, got %s", tt.wantPos, errs[0].Pos)
+			}
+			if len(program.Statements) != 2 {
+				t.Errorf("want parsing to resume at the next line, got %d statements", len(program.Statements))
+			}
+		})
+	}
+}
+
+func TestParseProgram_ParsesMacroDefinitionsAndCalls(t *testing.T) {
+	t.Parallel()
+	p := parser.New(newLexerFromString(`MACRO putc c, n
+.again
+SETA c
+ENDM
+putc 'h', 1+2
+putc`))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatal("didn't expect an error:", p.Errors())
+	}
+	if len(program.Statements) != 3 {
+		t.Fatalf("want 3 statements, got %d", len(program.Statements))
+	}
+	def := program.Statements[0].(ast.MacroDefinitionStatement)
+	var params []string
+	for _, param := range def.Params {
+		params = append(params, param.Value)
+	}
+	var body []string
+	for _, stmt := range def.Body {
+		body = append(body, stmt.TokenLiteral())
+	}
+	got := []string{def.Name.Value, strings.Join(params, ","), strings.Join(body, ",")}
+	want := []string{"putc", "c,n", ".again,SETA"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	wantEnd := token.Position{Offset: 34, Line: 4, Column: 5}
+	if wantEnd != def.End() {
+		t.Errorf("want end %s, got %s", wantEnd, def.End())
+	}
+
+	call := program.Statements[1].(ast.MacroCallStatement)
+	var args []string
+	for _, arg := range call.Arguments {
+		args = append(args, format(arg))
+	}
+	want = []string{"'h'", "(1 + 2)"}
+	if !cmp.Equal(want, args) {
+		t.Error(cmp.Diff(want, args))
+	}
+	call = program.Statements[2].(ast.MacroCallStatement)
+	if len(call.Arguments) != 0 {
+		t.Errorf("want no arguments, got %d", len(call.Arguments))
+	}
+}
+
+func TestParseProgram_RejectsInvalidMacroDefinitions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		input   string
+		wantPos string
+	}{
+		{"MACRO\nENDM", "1:6"},
+		{"MACRO m x,\nENDM", "1:11"},
+		{"MACRO m x y\nENDM", "1:11"},
+		{"MACRO m\nMACRO n\nENDM", "2:1"},
+		{"MACRO m\nHALT", "1:1"},
+		{"MACRO m\nINCLUDE \"lib.g\"\nENDM", "2:1"},
+		{"MACRO m\nENDM HALT", "2:6"},
+		{"putc 1,", "1:8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := parser.New(newLexerFromString(tt.input))
+			p.ParseProgram()
+			errs := p.Errors()
+			wantErr := parser.ErrInvalidSyntax
+			if len(errs) != 1 || !errors.Is(errs[0], wantErr) {
+				t.Fatalf("wanted error %v, got %v", wantErr, errs)
+			}
+			if tt.wantPos != errs[0].Pos.String() {
+				t.Errorf("want position %s, got %s", tt.wantPos, errs[0].Pos)
+			}
+		})
+	}
+}
+
+func TestParseProgram_ParsesIncludeStatement(t *testing.T) {
+	t.Parallel()
+	p := parser.New(newLexerFromString(`INCLUDE "lib/print.g"`))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatal("didn't expect an error:", p.Errors())
+	}
+	stmt := program.Statements[0].(ast.IncludeStatement)
+	want := "lib/print.g"
+	if want != stmt.Path.Value {
+		t.Errorf("want path %q, got %q", want, stmt.Path.Value)
+	}
+	wantEnd := token.Position{Offset: 21, Line: 1, Column: 22}
+	if wantEnd != stmt.End() {
+		t.Errorf("want end %s, got %s", wantEnd, stmt.End())
+	}
+}
@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
@@ -9,12 +11,30 @@ const (
 	LABEL_DEFINITION    = "LABEL_DEFINITION"
 	CONSTANT_DEFINITION = "CONSTANT_DEFINITION"
 	VARIABLE_DEFINITION = "VARIABLE_DEFINITION"
+	MACRO_DEFINITION    = "MACRO_DEFINITION"
+	MACRO_END           = "MACRO_END"
+	INCLUDE             = "INCLUDE"
 	IDENT               = "IDENT"
 	INT                 = "INT"
 	CHAR                = "CHAR"
 	STRING              = "STRING"
 	ARROW               = "ARROW"
 	ASTERISK            = "ASTERISK"
+
+	// Operators and delimiters in constant expressions
+	PLUS      = "+"
+	MINUS     = "-"
+	SLASH     = "/"
+	PERCENT   = "%"
+	SHL       = "<<"
+	SHR       = ">>"
+	AMPERSAND = "&"
+	PIPE      = "|"
+	TILDE     = "~"
+	LPAREN    = "("
+	RPAREN    = ")"
+	DOLLAR    = "$"
+	COMMA     = ","
 )
 
 var registers = map[string]TokenType{
@@ -43,19 +63,76 @@ var instructions = map[string]TokenType{
 	"POPA": INSTRUCTION,
 	"JUMP": INSTRUCTION,
 	"JXNZ": INSTRUCTION,
+	"CALL": INSTRUCTION,
+	"RTRN": INSTRUCTION,
+	"CMPA": INSTRUCTION,
+	"JZ":   INSTRUCTION,
+	"JNZ":  INSTRUCTION,
+	"JEQ":  INSTRUCTION,
+	"JLT":  INSTRUCTION,
+	"JGT":  INSTRUCTION,
+	"JSLT": INSTRUCTION,
+	"JSGT": INSTRUCTION,
+	"JANZ": INSTRUCTION,
+	"JAZ":  INSTRUCTION,
+	"JYNZ": INSTRUCTION,
+	"SUBA": INSTRUCTION,
+	"DIVA": INSTRUCTION,
+	"MODA": INSTRUCTION,
+	"ANDA": INSTRUCTION,
+	"ORA":  INSTRUCTION,
+	"XORA": INSTRUCTION,
+	"NOTA": INSTRUCTION,
+	"SHLA": INSTRUCTION,
+	"SHRA": INSTRUCTION,
+	"ROLA": INSTRUCTION,
+	"RORA": INSTRUCTION,
+	"INA":  INSTRUCTION,
+	"OUTC": INSTRUCTION,
+	"OUTN": INSTRUCTION,
 }
 
 var pragmas = map[string]TokenType{
-	"CONS": CONSTANT_DEFINITION,
-	"VARB": VARIABLE_DEFINITION,
+	"CONS":    CONSTANT_DEFINITION,
+	"VARB":    VARIABLE_DEFINITION,
+	"MACRO":   MACRO_DEFINITION,
+	"ENDM":    MACRO_END,
+	"INCLUDE": INCLUDE,
 }
 
 type TokenType string
 
 type Token struct {
-	Type    TokenType
-	Literal string // Possibily rename to Value
-	Line    int
+	Type     TokenType
+	Literal  string // Possibily rename to Value
+	Filename string
+	Line     int
+	Column   int
+	Offset   int      // byte offset of the token's first character
+	End      Position // position just after the token's last character
+}
+
+// Pos returns the position of the token's first character.
+func (t Token) Pos() Position {
+	return Position{Filename: t.Filename, Offset: t.Offset, Line: t.Line, Column: t.Column}
+}
+
+// Position is a location in the source of a program. Lines and columns
+// start at 1, and columns count characters rather than bytes.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+// String returns the position as "file.g:12:5", or "12:5" if there is no
+// filename.
+func (p Position) String() string {
+	if p.Filename == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
 }
 
 func LookupIdent(ident string) TokenType {
@@ -32,6 +32,33 @@ func TestLookupIdent(t *testing.T) {
 		{"POPA", token.INSTRUCTION},
 		{"JUMP", token.INSTRUCTION},
 		{"JXNZ", token.INSTRUCTION},
+		{"CALL", token.INSTRUCTION},
+		{"RTRN", token.INSTRUCTION},
+		{"CMPA", token.INSTRUCTION},
+		{"JZ", token.INSTRUCTION},
+		{"JNZ", token.INSTRUCTION},
+		{"JEQ", token.INSTRUCTION},
+		{"JLT", token.INSTRUCTION},
+		{"JGT", token.INSTRUCTION},
+		{"JSLT", token.INSTRUCTION},
+		{"JSGT", token.INSTRUCTION},
+		{"JANZ", token.INSTRUCTION},
+		{"JAZ", token.INSTRUCTION},
+		{"JYNZ", token.INSTRUCTION},
+		{"SUBA", token.INSTRUCTION},
+		{"DIVA", token.INSTRUCTION},
+		{"MODA", token.INSTRUCTION},
+		{"ANDA", token.INSTRUCTION},
+		{"ORA", token.INSTRUCTION},
+		{"XORA", token.INSTRUCTION},
+		{"NOTA", token.INSTRUCTION},
+		{"SHLA", token.INSTRUCTION},
+		{"SHRA", token.INSTRUCTION},
+		{"ROLA", token.INSTRUCTION},
+		{"RORA", token.INSTRUCTION},
+		{"INA", token.INSTRUCTION},
+		{"OUTC", token.INSTRUCTION},
+		{"OUTN", token.INSTRUCTION},
 		{"A", token.REGISTER},
 		{"X", token.REGISTER},
 		{"Y", token.REGISTER},
@@ -45,3 +72,20 @@ func TestLookupIdent(t *testing.T) {
 		}
 	}
 }
+
+func TestPosition_String(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		pos  token.Position
+		want string
+	}{
+		{token.Position{Line: 12, Column: 5}, "12:5"},
+		{token.Position{Filename: "file.g", Offset: 130, Line: 12, Column: 5}, "file.g:12:5"},
+	}
+	for _, tt := range tests {
+		got := tt.pos.String()
+		if tt.want != got {
+			t.Errorf("want %q, got %q", tt.want, got)
+		}
+	}
+}
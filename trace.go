@@ -0,0 +1,53 @@
+package gmachine
+
+import (
+	"fmt"
+	"io"
+)
+
+// TraceRecord describes a single instruction executed by the machine.
+type TraceRecord struct {
+	P           Word
+	Instruction Instruction
+	Before      Registers
+	After       Registers
+}
+
+func (r TraceRecord) String() string {
+	return fmt.Sprintf("%5d  %-16s A=%d X=%d Y=%d S=%d -> A=%d X=%d Y=%d S=%d",
+		r.P, r.Instruction,
+		r.Before.A, r.Before.X, r.Before.Y, r.Before.S,
+		r.After.A, r.After.X, r.After.Y, r.After.S,
+	)
+}
+
+// Tracer receives a TraceRecord for each instruction the machine executes.
+type Tracer interface {
+	Trace(TraceRecord)
+}
+
+// TracerFunc adapts an ordinary function to the Tracer interface.
+type TracerFunc func(TraceRecord)
+
+func (f TracerFunc) Trace(r TraceRecord) {
+	f(r)
+}
+
+// NewTextTracer returns a Tracer that writes each record to w on its own line.
+func NewTextTracer(w io.Writer) Tracer {
+	return TracerFunc(func(r TraceRecord) {
+		fmt.Fprintln(w, r)
+	})
+}
+
+// trace sends the record for the instruction at p, which has just executed,
+// to the machine's Tracer.
+func (g *Machine) trace(p Word, before Registers) {
+	inst, _ := Decode(g.Memory[g.MemOffset:], p)
+	g.Tracer.Trace(TraceRecord{
+		P:           p,
+		Instruction: inst,
+		Before:      before,
+		After:       g.Registers(),
+	})
+}
@@ -0,0 +1,83 @@
+package gmachine_test
+
+import (
+	"bytes"
+	"testing"
+
+	"gmachine"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTracer_ReceivesRecordForEachInstruction(t *testing.T) {
+	t.Parallel()
+	var records []gmachine.TraceRecord
+	g := gmachine.New(gmachine.WithTracer(gmachine.TracerFunc(func(r gmachine.TraceRecord) {
+		records = append(records, r)
+	})))
+	err := assembleAndRunFromString(g, "SETA 2\nPSHA\nHALT")
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	want := []gmachine.TraceRecord{
+		{
+			P:           0,
+			Instruction: gmachine.Instruction{Opcode: gmachine.OpSETA, Operand: 2, Kind: gmachine.OperandImmediate},
+			Before:      gmachine.Registers{},
+			After:       gmachine.Registers{P: 2, A: 2},
+		},
+		{
+			P:           2,
+			Instruction: gmachine.Instruction{Opcode: gmachine.OpPSHA},
+			Before:      gmachine.Registers{P: 2, A: 2},
+			After:       gmachine.Registers{P: 3, A: 2, S: 1},
+		},
+		{
+			P:           3,
+			Instruction: gmachine.Instruction{Opcode: gmachine.OpHALT},
+			Before:      gmachine.Registers{P: 3, A: 2, S: 1},
+			After:       gmachine.Registers{P: 4, A: 2, S: 1},
+		},
+	}
+	if !cmp.Equal(want, records) {
+		t.Error(cmp.Diff(want, records))
+	}
+}
+
+func TestTracer_RecordsFaultingInstruction(t *testing.T) {
+	t.Parallel()
+	var records []gmachine.TraceRecord
+	g := gmachine.New(gmachine.WithTracer(gmachine.TracerFunc(func(r gmachine.TraceRecord) {
+		records = append(records, r)
+	})))
+	err := assembleAndRunFromString(g, "POPA\nHALT")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(records) != 1 {
+		t.Fatalf("want 1 trace record, got %d", len(records))
+	}
+	want := "POPA"
+	got := records[0].Instruction.String()
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestNewTextTracer_WritesOneLinePerInstruction(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	g := gmachine.New(gmachine.WithTracer(gmachine.NewTextTracer(&buf)))
+	err := assembleAndRunFromString(g, "SETX 3\nDECX\nHALT")
+	if err != nil {
+		t.Fatal("didn't expect an error:", err)
+	}
+	want := "" +
+		"    0  SETX 3           A=0 X=0 Y=0 S=0 -> A=0 X=3 Y=0 S=0\n" +
+		"    2  DECX             A=0 X=3 Y=0 S=0 -> A=0 X=2 Y=0 S=0\n" +
+		"    3  HALT             A=0 X=2 Y=0 S=0 -> A=0 X=2 Y=0 S=0\n"
+	got := buf.String()
+	if want != got {
+		t.Error(cmp.Diff(want, got))
+	}
+}